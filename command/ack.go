@@ -0,0 +1,76 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lbzss/elasticsearch-alert/command/alert"
+	"github.com/lbzss/elasticsearch-alert/config"
+)
+
+const ackUsage = `Usage: elasticsearch-alert ack [options] <fingerprint>...
+
+Acknowledges the alerts with the given fingerprints, which stops the
+escalation of their rules. The fingerprint of an alert is its ID. It
+changes as keys start or stop firing, but any fingerprint of a rule's
+escalation acknowledges it.
+
+Options:
+`
+
+func runAck(args []string) int {
+	var (
+		path    string
+		author  string
+		comment string
+	)
+	flags := flag.NewFlagSet("ack", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), ackUsage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&path, "file", "", "path of the acknowledgements file")
+	flags.StringVar(&author, "author", os.Getenv("USER"), "who acknowledges the alerts")
+	flags.StringVar(&comment, "comment", "", "comment on the acknowledgement")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 1
+	}
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 1
+	}
+
+	if path == "" {
+		f, err := config.AcksFile()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		path = f
+	}
+
+	acks, err := alert.OpenAckStore(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	for _, fingerprint := range flags.Args() {
+		err := acks.Add(&alert.Ack{
+			Fingerprint: fingerprint,
+			CreatedBy:   author,
+			Comment:     comment,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error acknowledging alert %s: %v\n", fingerprint, err)
+			return 1
+		}
+	}
+	return 0
+}
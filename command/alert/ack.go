@@ -0,0 +1,155 @@
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ack acknowledges the alert with the given fingerprint, which stops its
+// escalation.
+type Ack struct {
+	Fingerprint string    `json:"fingerprint"`
+	CreatedBy   string    `json:"created_by"`
+	Comment     string    `json:"comment,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AckStore holds acknowledgements persisted in a JSON file. Like the
+// SilenceStore it reloads the file whenever it changes.
+type AckStore struct {
+	file *jsonFile
+	acks map[string]*Ack
+	lock *sync.Mutex
+}
+
+// OpenAckStore loads the acknowledgements from the file at path. The file
+// does not need to exist yet.
+func OpenAckStore(path string) (*AckStore, error) {
+	s := &AckStore{
+		file: &jsonFile{path: path},
+		acks: make(map[string]*Ack),
+		lock: new(sync.Mutex),
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *AckStore) reload() error {
+	var acks []*Ack
+	changed, err := s.file.load(&acks, func() { acks = nil })
+	if err != nil || !changed {
+		return err
+	}
+
+	s.acks = make(map[string]*Ack, len(acks))
+	for _, ack := range acks {
+		s.acks[ack.Fingerprint] = ack
+	}
+	return nil
+}
+
+func (s *AckStore) save() error {
+	acks := make([]*Ack, 0, len(s.acks))
+	for _, ack := range s.acks {
+		acks = append(acks, ack)
+	}
+	sort.Slice(acks, func(i, j int) bool {
+		return acks[i].CreatedAt.Before(acks[j].CreatedAt)
+	})
+	return s.file.save(acks)
+}
+
+// Add persists the acknowledgement.
+func (s *AckStore) Add(ack *Ack) error {
+	if ack.Fingerprint == "" {
+		return errors.New("no fingerprint provided")
+	}
+	if ack.CreatedBy == "" {
+		return errors.New("no author provided")
+	}
+	if ack.CreatedAt.IsZero() {
+		ack.CreatedAt = time.Now()
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+	s.acks[ack.Fingerprint] = ack
+	return s.save()
+}
+
+// Remove forgets the acknowledgement of the alert with the fingerprint,
+// if any.
+func (s *AckStore) Remove(fingerprint string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+	if _, ok := s.acks[fingerprint]; !ok {
+		return nil
+	}
+	delete(s.acks, fingerprint)
+	return s.save()
+}
+
+// Expire removes the acknowledgements for which stale returns true.
+func (s *AckStore) Expire(stale func(*Ack) bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+
+	removed := false
+	for fingerprint, ack := range s.acks {
+		if stale(ack) {
+			delete(s.acks, fingerprint)
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+	return s.save()
+}
+
+// Acked returns the acknowledgement of the alert with the fingerprint, or
+// nil if it has not been acknowledged. If the file can't be reloaded the
+// previously loaded acknowledgements are used.
+func (s *AckStore) Acked(fingerprint string) *Ack {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.reload(); err != nil {
+		fmt.Println("error reloading acknowledgements", "error", err)
+	}
+	return s.acks[fingerprint]
+}
+
+// List returns all acknowledgements ordered by when they were made.
+func (s *AckStore) List() ([]*Ack, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	acks := make([]*Ack, 0, len(s.acks))
+	for _, ack := range s.acks {
+		acks = append(acks, ack)
+	}
+	sort.Slice(acks, func(i, j int) bool {
+		return acks[i].CreatedAt.Before(acks[j].CreatedAt)
+	})
+	return acks, nil
+}
@@ -5,45 +5,200 @@ import (
 	"fmt"
 	"math/rand"
 	"time"
+
+	"github.com/lbzss/elasticsearch-alert/utils"
 )
 
 type Field struct {
 	Key   string `json:"key" mapstructure:"key"`
 	Count int    `json:"doc_count" mapstructure:"doc_count"`
+
+	// Metrics are the values of the metric sub-aggregations of the
+	// bucket by aggregation name. Single-value metrics such as 'avg' are
+	// numbers, 'stats' and 'percentiles' are maps of their values, e.g.
+	// {"99.0": 812}, and 'top_hits' is the list of the hits' '_source's
+	Metrics map[string]interface{} `json:"metrics,omitempty" mapstructure:"-"`
+
+	// DiscoverURL links to the documents of this bucket in Kibana
+	DiscoverURL string `json:"discover_url,omitempty" mapstructure:"-"`
+}
+
+// Metric returns the metric at path, e.g. 'latency' or 'latency.99.0',
+// or nil if there is none. Keys containing dots, like the percentiles
+// of a 'percentiles' aggregation, are matched as by utils.Lookup.
+func (f *Field) Metric(path string) interface{} {
+	v, _ := utils.Lookup(f.Metrics, path)
+	return v
 }
 
 type Record struct {
+	// RuleName is the rule the record is from. It is only set on the
+	// records of digest summaries, which may combine several rules
+	RuleName  string   `json:"rule,omitempty"`
 	Filter    string   `json:"filter,omitempty"`
 	Text      string   `json:"text,omitempty"`
 	BodyField bool     `json:"-"`
 	Fields    []*Field `json:"fields,omitempty"`
 }
 
+// Attachment is a file sent along with an alert by the outputs that
+// support it, e.g. the hits of a rule rendered as CSV.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+const (
+	SeverityCritical = "critical"
+	SeverityError    = "error"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+// State is the state of the condition an alert reports on
+type State string
+
+const (
+	// StatePending means the rule matched but has not done so for long
+	// enough to fire. Pending alerts are not sent
+	StatePending State = "pending"
+
+	// StateFiring means the rule matches
+	StateFiring State = "firing"
+
+	// StateResolved means the rule was firing but no longer matches
+	StateResolved State = "resolved"
+)
+
 type Alert struct {
+	// ID is the fingerprint of the alert, see Fingerprint
 	ID       string
 	RuleName string
+	State    State
 	Methods  []Method
 	Records  []*Record
+
+	// Hits are the raw hits matched by the rule's query, if any
+	Hits []map[string]interface{}
+
+	// Attachments are files to send along with the alert, if the rule
+	// renders its hits into one
+	Attachments []*Attachment
+
+	// Message is the alert rendered with the template of the rule or of
+	// the output it is written to. It is empty if there is no template
+	Message string
+
+	// DiscoverURL links to the documents matched by the rule's query
+	// during this run in Kibana, if Kibana is configured
+	DiscoverURL string
+
+	// Labels identify the alert for silences and routing, e.g. its team
+	// or service
+	Labels map[string]string
+
+	// Annotations carry additional information for responders, e.g. a
+	// description or a runbook URL. They are rendered per alert
+	Annotations map[string]string
+
+	// Severity is one of the Severity* constants, or empty if the rule
+	// doesn't specify one
+	Severity string
+
+	// EndsAt is when a firing alert is considered over unless the rule
+	// fires again, i.e. a little after its next run
+	EndsAt time.Time
+
+	// Escalation lists who to notify, in addition to Methods, the longer
+	// the alert's rule keeps firing without being acknowledged
+	Escalation []*EscalationStep
+
+	// StillFiring is set on resolved alerts if other keys of the rule are
+	// still firing, in which case the rule's escalation goes on
+	StillFiring bool
+
+	// Suppressed is the number of alerts for the same rule that were
+	// throttled since the last one was sent
+	Suppressed int
+
+	// Throttled is true if the rule's realert throttle suppressed the
+	// alert. It is written to no method, but keeps the alert firing for
+	// inhibitions and escalations
+	Throttled bool
+
+	// DedupWindow is the period during which alerts with the same ID and
+	// state are handled at most once. Repeats are dropped before they are
+	// written to any method. A resolved alert ends the period of the
+	// firing alerts of its rule
+	DedupWindow time.Duration
 }
 
 type Method interface {
-	Write(context.Context, string, []*Record) error
+	Write(context.Context, *Alert) error
+}
+
+// checkInterval is how often the handler checks whether any digest or
+// escalation step is due
+const checkInterval = time.Second
+
+type HandlerConfig struct {
+	// Silences, if set, are checked before every write and silenced
+	// alerts are dropped
+	Silences *SilenceStore
+
+	// Route, if set, decides which of Receivers get the alerts that come
+	// without methods of their own
+	Route     *Route
+	Receivers map[string][]Method
+
+	// Inhibitor, if set, keeps track of firing alerts and drops alerts
+	// inhibited by them
+	Inhibitor *Inhibitor
+
+	// Acks, if set, are checked to stop the escalation of acknowledged
+	// alerts
+	Acks *AckStore
 }
 
 type Handler struct {
 	rand   *rand.Rand
 	StopCh chan struct{}
 	DoneCh chan struct{}
+
+	silences  *SilenceStore
+	route     *Route
+	receivers map[string][]Method
+	inhibitor *Inhibitor
+
+	escalations *escalator
 }
 
-func NewHandler() *Handler {
+func NewHandler(config *HandlerConfig) *Handler {
+	if config == nil {
+		config = &HandlerConfig{}
+	}
+
 	return &Handler{
 		rand:   rand.New(rand.NewSource(int64(time.Now().Nanosecond()))),
 		StopCh: make(chan struct{}),
 		DoneCh: make(chan struct{}),
+
+		silences:  config.Silences,
+		route:     config.Route,
+		receivers: config.Receivers,
+		inhibitor: config.Inhibitor,
+
+		escalations: newEscalator(config.Acks),
 	}
 }
 
+// Escalating reports whether the alert with the fingerprint is being
+// escalated and can be acknowledged.
+func (h *Handler) Escalating(fingerprint string) bool {
+	return h.escalations.escalating(fingerprint)
+}
+
 func (h *Handler) Run(ctx context.Context, outputChan <-chan *Alert) {
 	defer func() {
 		close(h.DoneCh)
@@ -51,19 +206,65 @@ func (h *Handler) Run(ctx context.Context, outputChan <-chan *Alert) {
 
 	alertCh := make(chan func() (int, error), 8)
 	active := newInventory()
+	// handled holds until when repeats of the alerts with a dedup window
+	// are dropped
+	handled := make(map[dedupKey]time.Time)
+	digests := make(map[*Digest]struct{})
+	escalations := h.escalations
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
 
-	alertFunc := func(ctx context.Context, alertID, rule string, method Method, records []*Record) func() (int, error) {
+	alertFunc := func(ctx context.Context, alertID string, method Method, alert *Alert) func() (int, error) {
 		return func() (int, error) {
 			if active.remaining(alertID) < 1 {
 				active.deregister(alertID)
 				return 0, nil
 			}
+			a := alert
+			if h.silences != nil {
+				unsilenced, silence := h.silences.Silenced(a, time.Now())
+				if unsilenced == nil {
+					fmt.Println("alert silenced", "rule", a.RuleName, "silence", silence.ID)
+					active.deregister(alertID)
+					return 0, nil
+				}
+				a = unsilenced
+			}
+			if h.inhibitor != nil {
+				if source := h.inhibitor.Inhibited(a, time.Now()); source != nil {
+					fmt.Println("alert inhibited", "rule", a.RuleName, "inhibited_by", source.RuleName)
+					active.deregister(alertID)
+					return 0, nil
+				}
+			}
 			active.decrement(alertID)
-			err := method.Write(ctx, rule, records)
+			err := method.Write(ctx, a)
+			if err == nil {
+				active.deregister(alertID)
+				return 0, nil
+			}
 			return active.remaining(alertID), err
 		}
 	}
 
+	// enqueue queues a write without blocking the loop if the queue is
+	// full, since the loop is the one draining it
+	enqueue := func(alertID string, method Method, alert *Alert) {
+		active.register(alertID)
+		writeAlert := alertFunc(ctx, alertID, method, alert)
+		select {
+		case alertCh <- writeAlert:
+		default:
+			go func() {
+				select {
+				case <-ctx.Done():
+				case alertCh <- writeAlert:
+				}
+			}()
+		}
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -71,10 +272,67 @@ func (h *Handler) Run(ctx context.Context, outputChan <-chan *Alert) {
 		case <-h.StopCh:
 			return
 		case alert := <-outputChan:
-			for i, method := range alert.Methods {
-				alertMethodID := fmt.Sprintf("%d|%s", i, alert.ID)
-				active.register(alertMethodID)
-				alertCh <- alertFunc(ctx, alertMethodID, alert.RuleName, method, alert.Records)
+			if h.inhibitor != nil {
+				h.inhibitor.Observe(alert)
+			}
+
+			// Digests of escalation steps collect alerts too and must be
+			// flushed like those of the alert's own methods
+			for _, step := range alert.Escalation {
+				for _, method := range step.Methods {
+					if d, ok := method.(*Digest); ok {
+						digests[d] = struct{}{}
+					}
+				}
+			}
+
+			now := time.Now()
+			for _, w := range escalations.observe(alert, now) {
+				enqueue(w.id, w.method, w.alert)
+			}
+
+			if alert.Throttled {
+				continue
+			}
+
+			for key, expires := range handled {
+				if !now.Before(expires) {
+					delete(handled, key)
+				}
+			}
+			// Once its rule resolves, an alert firing again is news
+			if alert.State == StateResolved {
+				for key := range handled {
+					if key.rule == alert.RuleName && key.state == StateFiring {
+						delete(handled, key)
+					}
+				}
+			}
+			if alert.DedupWindow > 0 {
+				key := dedupKey{rule: alert.RuleName, state: alert.State, id: alert.ID}
+				if _, ok := handled[key]; ok {
+					fmt.Println("dropping duplicate alert", "rule", alert.RuleName, "fingerprint", alert.ID)
+					continue
+				}
+				handled[key] = now.Add(alert.DedupWindow)
+			}
+
+			for methodID, method := range h.methods(alert) {
+				if d, ok := method.(*Digest); ok {
+					digests[d] = struct{}{}
+				}
+
+				enqueue(fmt.Sprintf("%s|%s|%s", methodID, alert.State, alert.ID), method, alert)
+			}
+		case now := <-ticker.C:
+			for d := range digests {
+				if summary := d.flush(now); summary != nil {
+					enqueue(fmt.Sprintf("digest|%p|%s", d, summary.ID), d.method, summary)
+				}
+			}
+
+			for _, w := range escalations.due(now) {
+				enqueue(w.id, w.method, w.alert)
 			}
 		case writeAlert := <-alertCh:
 			select {
@@ -98,6 +356,39 @@ func (h *Handler) Run(ctx context.Context, outputChan <-chan *Alert) {
 	}
 }
 
+// dedupKey identifies the alerts that are duplicates of each other.
+type dedupKey struct {
+	rule  string
+	state State
+	id    string
+}
+
+// methods returns the methods the alert is written to, keyed by an ID
+// that is unique among them. These are the alert's own methods or, if it
+// has neither methods nor escalation steps, the ones of the receivers it
+// is routed to.
+func (h *Handler) methods(alert *Alert) map[string]Method {
+	methods := make(map[string]Method)
+	if len(alert.Methods) > 0 || len(alert.Escalation) > 0 || h.route == nil {
+		for i, method := range alert.Methods {
+			methods[fmt.Sprintf("%d", i)] = method
+		}
+		return methods
+	}
+
+	for _, receiver := range h.route.Match(alert) {
+		rm, ok := h.receivers[receiver]
+		if !ok {
+			fmt.Println("alert routed to unknown receiver", "rule", alert.RuleName, "receiver", receiver)
+			continue
+		}
+		for i, method := range rm {
+			methods[fmt.Sprintf("%s/%d", receiver, i)] = method
+		}
+	}
+	return methods
+}
+
 func (h *Handler) newBackoff() time.Duration {
 	return 2*time.Second + time.Duration(h.rand.Int63()%int64(time.Second*2)-int64(time.Second))
 }
@@ -0,0 +1,147 @@
+package alert
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+const digestTextDelimiter = "\n\n"
+
+// Digest accumulates the firing alerts written to it and hands them to
+// the wrapped method as a single summary alert on every tick of its
+// schedule. Resolved alerts are passed through immediately.
+type Digest struct {
+	method   Method
+	schedule cron.Schedule
+	next     time.Time
+	alerts   []*Alert
+	lock     *sync.Mutex
+}
+
+func NewDigest(method Method, schedule cron.Schedule) *Digest {
+	return &Digest{
+		method:   method,
+		schedule: schedule,
+		next:     schedule.Next(time.Now()),
+		lock:     new(sync.Mutex),
+	}
+}
+
+func (d *Digest) Write(ctx context.Context, a *Alert) error {
+	if a.State == StateResolved {
+		return d.method.Write(ctx, a)
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.alerts = append(d.alerts, a)
+	return nil
+}
+
+// flush returns the summary of the alerts accumulated so far if the
+// digest is due at time now, or nil otherwise.
+func (d *Digest) flush(now time.Time) *Alert {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if now.Before(d.next) {
+		return nil
+	}
+	d.next = d.schedule.Next(now)
+
+	if len(d.alerts) < 1 {
+		return nil
+	}
+	alerts := d.alerts
+	d.alerts = nil
+
+	names := make([]string, 0)
+	seen := make(map[string]struct{})
+	records := make([]*Record, 0)
+	attachments := make([]*Attachment, 0)
+	suppressed := 0
+	for _, a := range alerts {
+		if _, ok := seen[a.RuleName]; !ok {
+			seen[a.RuleName] = struct{}{}
+			names = append(names, a.RuleName)
+		}
+		for _, record := range a.Records {
+			r := *record
+			r.RuleName = a.RuleName
+			records = append(records, &r)
+		}
+		attachments = append(attachments, a.Attachments...)
+		suppressed += a.Suppressed
+	}
+	records = MergeRecords(records)
+	rule := strings.Join(names, ", ")
+
+	return &Alert{
+		ID:          Fingerprint(rule, records, nil),
+		RuleName:    rule,
+		State:       StateFiring,
+		Records:     records,
+		Attachments: attachments,
+		Suppressed:  suppressed,
+	}
+}
+
+// MergeRecords merges records with the same rule and filter. Fields with
+// the same key are merged into one whose count is the sum of theirs, and
+// texts are concatenated. Merged fields keep no metrics, since those of
+// different runs can't be combined.
+func MergeRecords(records []*Record) []*Record {
+	type recordKey struct {
+		rule   string
+		filter string
+	}
+
+	merged := make([]*Record, 0)
+	byKey := make(map[recordKey]*Record)
+	fieldsByKey := make(map[recordKey]map[string]*Field)
+	for _, record := range records {
+		key := recordKey{rule: record.RuleName, filter: record.Filter}
+		m, ok := byKey[key]
+		if !ok {
+			m = &Record{
+				RuleName:  record.RuleName,
+				Filter:    record.Filter,
+				BodyField: record.BodyField,
+			}
+			byKey[key] = m
+			fieldsByKey[key] = make(map[string]*Field)
+			merged = append(merged, m)
+		}
+
+		if record.Text != "" {
+			if m.Text == "" {
+				m.Text = record.Text
+			} else {
+				m.Text += digestTextDelimiter + record.Text
+			}
+		}
+
+		fields := fieldsByKey[key]
+		for _, field := range record.Fields {
+			if f, ok := fields[field.Key]; ok {
+				f.Count += field.Count
+				f.Metrics = nil
+				continue
+			}
+			f := *field
+			fields[field.Key] = &f
+			m.Fields = append(m.Fields, &f)
+		}
+	}
+
+	for _, m := range merged {
+		sort.SliceStable(m.Fields, func(i, j int) bool {
+			return m.Fields[i].Count > m.Fields[j].Count
+		})
+	}
+	return merged
+}
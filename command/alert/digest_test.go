@@ -0,0 +1,94 @@
+package alert
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+func TestDigest(t *testing.T) {
+	rec := new(recordingMethod)
+	d := NewDigest(rec, cron.Every(time.Hour))
+
+	alerts := []*Alert{
+		{RuleName: "rule", State: StateFiring, Records: []*Record{
+			{Filter: "aggregations.hosts.buckets", Fields: []*Field{{Key: "foo", Count: 1, Metrics: map[string]interface{}{"avg": 1}}, {Key: "bar", Count: 5}}},
+		}},
+		{RuleName: "rule", State: StateFiring, Records: []*Record{
+			{Filter: "aggregations.hosts.buckets", Fields: []*Field{{Key: "foo", Count: 10, Metrics: map[string]interface{}{"avg": 2}}}},
+			{Filter: "hits.hits._source", Text: "hit"},
+		}},
+		{RuleName: "other", State: StateFiring, Records: []*Record{
+			{Filter: "aggregations.hosts.buckets", Fields: []*Field{{Key: "foo", Count: 100}}},
+		}},
+		{RuleName: "rule", State: StateResolved},
+	}
+	for _, a := range alerts {
+		if err := d.Write(context.Background(), a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(rec.alerts) != 1 || rec.alerts[0].State != StateResolved {
+		t.Fatal("resolved alerts should be passed through")
+	}
+
+	if summary := d.flush(time.Now()); summary != nil {
+		t.Fatal("digest should not be flushed before it is due")
+	}
+
+	summary := d.flush(time.Now().Add(time.Hour))
+	if summary == nil {
+		t.Fatal("expected a summary once the digest is due")
+	}
+	if len(summary.Records) != 3 {
+		t.Fatalf("expected records to be merged by rule and filter, got %d records", len(summary.Records))
+	}
+	fields := summary.Records[0].Fields
+	if len(fields) != 2 || fields[0].Key != "foo" || fields[0].Count != 11 || fields[1].Count != 5 {
+		t.Fatalf("expected counts to be summed by key, got %+v %+v", fields[0], fields[1])
+	}
+	if fields[0].Metrics != nil {
+		t.Error("expected merged fields to keep no metrics")
+	}
+	if other := summary.Records[2]; other.RuleName != "other" || other.Fields[0].Count != 100 {
+		t.Errorf("expected the records of other rules to be kept apart, got %+v", other)
+	}
+	if summary.RuleName != "rule, other" {
+		t.Errorf("expected the summary to name all rules, got %q", summary.RuleName)
+	}
+
+	if summary := d.flush(time.Now().Add(3 * time.Hour)); summary != nil {
+		t.Fatal("empty digests should not be sent")
+	}
+}
+
+func TestHandlerFlushesEscalationDigests(t *testing.T) {
+	h := NewHandler(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	outputCh := make(chan *Alert)
+	go h.Run(ctx, outputCh)
+
+	rec := new(recordingMethod)
+	d := NewDigest(rec, cron.Every(time.Second))
+	outputCh <- &Alert{
+		ID:         "fingerprint",
+		RuleName:   "rule",
+		State:      StateFiring,
+		EndsAt:     time.Now().Add(time.Hour),
+		Records:    []*Record{{Filter: "hits.hits._source", Text: "hit"}},
+		Escalation: []*EscalationStep{{Methods: []Method{d}}},
+	}
+
+	// The step is due on the first check and its digest on the next one
+	time.Sleep(2*checkInterval + checkInterval/2)
+
+	cancel()
+	<-h.DoneCh
+	if len(rec.alerts) != 1 {
+		t.Fatalf("expected the digest of the escalation step to be flushed, got %d alerts", len(rec.alerts))
+	}
+}
@@ -0,0 +1,219 @@
+package alert
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// ackGracePeriod is how long an acknowledgement is kept without an
+	// escalation of its alert, e.g. while the daemon restarts
+	ackGracePeriod = time.Hour
+
+	// ackExpiryInterval is how often stale acknowledgements are removed
+	ackExpiryInterval = time.Minute
+)
+
+// EscalationStep notifies Methods once the rule of an alert has been
+// firing for After without being acknowledged.
+type EscalationStep struct {
+	After   time.Duration
+	Methods []Method
+}
+
+type escalation struct {
+	alert   *Alert
+	started time.Time
+	next    int
+	acked   bool
+
+	// fingerprints are those of all alerts observed for the escalation,
+	// any of which acknowledges it
+	fingerprints map[string]struct{}
+}
+
+// pendingWrite is a write of an alert to a method that the alert handler
+// should queue.
+type pendingWrite struct {
+	id     string
+	method Method
+	alert  *Alert
+}
+
+// escalator follows the escalation of the rules with firing alerts until
+// they are acknowledged, resolved or end. Escalations are kept by rule
+// rather than by fingerprint, which changes whenever a key starts or
+// stops firing.
+type escalator struct {
+	acks    *AckStore
+	active  map[string]*escalation
+	expired time.Time
+	lock    *sync.Mutex
+}
+
+func newEscalator(acks *AckStore) *escalator {
+	return &escalator{
+		acks:   acks,
+		active: make(map[string]*escalation),
+		lock:   new(sync.Mutex),
+	}
+}
+
+// escalating reports whether the alert with the fingerprint is being
+// escalated, i.e. whether acknowledging it has any effect.
+func (e *escalator) escalating(fingerprint string) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for _, esc := range e.active {
+		if _, ok := esc.fingerprints[fingerprint]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// observe starts or refreshes the escalation of the rule of a firing
+// alert. For a resolved alert it returns the writes notifying the steps
+// of the rule's escalation that were reached, and stops the escalation
+// unless other keys of the rule are still firing.
+func (e *escalator) observe(a *Alert, now time.Time) []pendingWrite {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if a.State != StateResolved {
+		if len(a.Escalation) < 1 {
+			return nil
+		}
+		esc, ok := e.active[a.RuleName]
+		switch {
+		case !ok:
+			esc = &escalation{
+				alert:        a,
+				started:      now,
+				fingerprints: make(map[string]struct{}),
+			}
+			e.active[a.RuleName] = esc
+		case a.Throttled:
+			// Throttled alerts only extend the escalation, its steps are
+			// notified of the alert that was sent
+			cp := *esc.alert
+			cp.EndsAt = a.EndsAt
+			esc.alert = &cp
+		default:
+			esc.alert = a
+		}
+		esc.fingerprints[a.ID] = struct{}{}
+		return nil
+	}
+
+	esc, ok := e.active[a.RuleName]
+	if !ok {
+		return nil
+	}
+	writes := make([]pendingWrite, 0)
+	for i := 0; i < esc.next; i++ {
+		for j, method := range esc.alert.Escalation[i].Methods {
+			writes = append(writes, pendingWrite{
+				id:     fmt.Sprintf("escalation/%d/%d|%s|%s", i, j, a.State, a.ID),
+				method: method,
+				alert:  a,
+			})
+		}
+	}
+	if !a.StillFiring {
+		e.stop(a.RuleName)
+	}
+	return writes
+}
+
+// due returns the writes of the escalation steps reached at time now.
+func (e *escalator) due(now time.Time) []pendingWrite {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.expireAcks(now)
+
+	writes := make([]pendingWrite, 0)
+	for rule, esc := range e.active {
+		if !esc.alert.EndsAt.IsZero() && !now.Before(esc.alert.EndsAt) {
+			e.stop(rule)
+			continue
+		}
+
+		if esc.acked {
+			continue
+		}
+		if ack := e.acked(esc); ack != nil {
+			fmt.Println("alert acknowledged", "rule", rule, "fingerprint", ack.Fingerprint, "by", ack.CreatedBy)
+			esc.acked = true
+			continue
+		}
+
+		steps := esc.alert.Escalation
+		for esc.next < len(steps) && !now.Before(esc.started.Add(steps[esc.next].After)) {
+			for j, method := range steps[esc.next].Methods {
+				writes = append(writes, pendingWrite{
+					id:     fmt.Sprintf("escalation/%d/%d|%s|%s", esc.next, j, esc.alert.State, esc.alert.ID),
+					method: method,
+					alert:  esc.alert,
+				})
+			}
+			esc.next++
+		}
+	}
+	return writes
+}
+
+// acked returns the acknowledgement of any of the escalation's alerts,
+// or nil if there is none.
+func (e *escalator) acked(esc *escalation) *Ack {
+	if e.acks == nil {
+		return nil
+	}
+	for fingerprint := range esc.fingerprints {
+		if ack := e.acks.Acked(fingerprint); ack != nil {
+			return ack
+		}
+	}
+	return nil
+}
+
+func (e *escalator) stop(rule string) {
+	esc, ok := e.active[rule]
+	if !ok {
+		return
+	}
+	delete(e.active, rule)
+	if e.acks == nil {
+		return
+	}
+	for fingerprint := range esc.fingerprints {
+		if err := e.acks.Remove(fingerprint); err != nil {
+			fmt.Println("error removing acknowledgement", "fingerprint", fingerprint, "error", err)
+		}
+	}
+}
+
+// expireAcks removes the acknowledgements of alerts that are no longer
+// escalated, e.g. because they stopped firing while the daemon was down
+// or were never firing at all. It does so at most every
+// ackExpiryInterval.
+func (e *escalator) expireAcks(now time.Time) {
+	if e.acks == nil || now.Sub(e.expired) < ackExpiryInterval {
+		return
+	}
+	e.expired = now
+
+	escalated := make(map[string]struct{})
+	for _, esc := range e.active {
+		for fingerprint := range esc.fingerprints {
+			escalated[fingerprint] = struct{}{}
+		}
+	}
+	err := e.acks.Expire(func(ack *Ack) bool {
+		_, ok := escalated[ack.Fingerprint]
+		return !ok && now.Sub(ack.CreatedAt) >= ackGracePeriod
+	})
+	if err != nil {
+		fmt.Println("error expiring acknowledgements", "error", err)
+	}
+}
@@ -0,0 +1,191 @@
+package alert
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEscalator(t *testing.T) {
+	acks, err := OpenAckStore(filepath.Join(t.TempDir(), "acks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	first, second := new(recordingMethod), new(recordingMethod)
+	a := &Alert{
+		ID:       "fingerprint",
+		RuleName: "rule",
+		State:    StateFiring,
+		EndsAt:   now.Add(time.Hour),
+		Escalation: []*EscalationStep{
+			{Methods: []Method{first}},
+			{After: 15 * time.Minute, Methods: []Method{second}},
+		},
+	}
+
+	e := newEscalator(acks)
+	e.observe(a, now)
+	if writes := e.due(now); len(writes) != 1 || writes[0].method != first {
+		t.Fatalf("expected the first step to be due immediately, got %d writes", len(writes))
+	}
+	if writes := e.due(now.Add(time.Minute)); len(writes) != 0 {
+		t.Fatal("steps should only be notified once")
+	}
+	e.observe(a, now.Add(5*time.Minute))
+	if writes := e.due(now.Add(15 * time.Minute)); len(writes) != 1 || writes[0].method != second {
+		t.Fatal("expected the second step to be due after 15 minutes")
+	}
+
+	resolved := &Alert{ID: "other", RuleName: "rule", State: StateResolved}
+	if writes := e.observe(resolved, now.Add(20*time.Minute)); len(writes) != 2 {
+		t.Fatalf("expected resolved alert to notify both steps, got %d writes", len(writes))
+	}
+	if len(e.active) != 0 {
+		t.Fatal("resolved alerts should stop escalations of their rule")
+	}
+
+	e.observe(a, now)
+	e.due(now)
+	if err := acks.Add(&Ack{Fingerprint: a.ID, CreatedBy: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if writes := e.due(now.Add(15 * time.Minute)); len(writes) != 0 {
+		t.Fatal("acknowledged alerts should not escalate")
+	}
+
+	if writes := e.due(now.Add(2 * time.Hour)); len(writes) != 0 || len(e.active) != 0 {
+		t.Fatal("escalations should stop once their alert ends")
+	}
+	if acks.Acked(a.ID) != nil {
+		t.Fatal("acknowledgements should be removed once their escalation stops")
+	}
+}
+
+func TestEscalatorThrottled(t *testing.T) {
+	now := time.Now()
+	first, second := new(recordingMethod), new(recordingMethod)
+	steps := []*EscalationStep{
+		{Methods: []Method{first}},
+		{After: 15 * time.Minute, Methods: []Method{second}},
+	}
+	sent := &Alert{ID: "fingerprint", RuleName: "rule", State: StateFiring, EndsAt: now.Add(10 * time.Minute), Escalation: steps}
+
+	e := newEscalator(nil)
+	e.observe(sent, now)
+	e.due(now)
+
+	// The rule keeps firing every 5 minutes, throttled by its realert
+	for m := 5; m <= 15; m += 5 {
+		at := now.Add(time.Duration(m) * time.Minute)
+		e.observe(&Alert{ID: "fingerprint", RuleName: "rule", State: StateFiring, EndsAt: at.Add(10 * time.Minute), Escalation: steps, Throttled: true}, at)
+		if m < 15 {
+			e.due(at)
+		}
+	}
+
+	writes := e.due(now.Add(15 * time.Minute))
+	if len(writes) != 1 || writes[0].method != second {
+		t.Fatalf("expected throttled alerts to keep the escalation going, got %d writes", len(writes))
+	}
+	if writes[0].alert.Throttled {
+		t.Fatal("steps should be notified of the alert that was sent")
+	}
+}
+
+func TestEscalatorExpiresAcks(t *testing.T) {
+	acks, err := OpenAckStore(filepath.Join(t.TempDir(), "acks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	e := newEscalator(acks)
+	e.observe(&Alert{
+		ID:         "firing",
+		RuleName:   "rule",
+		State:      StateFiring,
+		EndsAt:     now.Add(3 * time.Hour),
+		Escalation: []*EscalationStep{{After: time.Hour, Methods: []Method{new(recordingMethod)}}},
+	}, now)
+	for _, fingerprint := range []string{"firing", "stale"} {
+		if err := acks.Add(&Ack{Fingerprint: fingerprint, CreatedBy: "test", CreatedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e.due(now.Add(time.Minute))
+	if acks.Acked("stale") == nil {
+		t.Fatal("acknowledgements should be kept during their grace period")
+	}
+
+	e.due(now.Add(ackGracePeriod))
+	if acks.Acked("stale") != nil {
+		t.Error("acknowledgements of alerts that are not escalated should expire")
+	}
+	if acks.Acked("firing") == nil {
+		t.Error("acknowledgements of escalated alerts should be kept")
+	}
+}
+
+func TestEscalatorChangingKeys(t *testing.T) {
+	acks, err := OpenAckStore(filepath.Join(t.TempDir(), "acks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	first, second := new(recordingMethod), new(recordingMethod)
+	steps := []*EscalationStep{
+		{Methods: []Method{first}},
+		{After: 10 * time.Minute, Methods: []Method{second}},
+	}
+	// The rule runs every minute and its set of keys, hence its
+	// fingerprint, changes every 3 minutes
+	firing := func(m int) *Alert {
+		at := now.Add(time.Duration(m) * time.Minute)
+		return &Alert{
+			ID:         fmt.Sprintf("fingerprint-%d", m/3),
+			RuleName:   "rule",
+			State:      StateFiring,
+			EndsAt:     at.Add(2 * time.Minute),
+			Escalation: steps,
+		}
+	}
+
+	e := newEscalator(acks)
+	counts := make(map[Method]int)
+	for m := 0; m < 30; m++ {
+		at := now.Add(time.Duration(m) * time.Minute)
+		e.observe(firing(m), at)
+		if m == 20 {
+			resolved := &Alert{ID: "resolved", RuleName: "rule", State: StateResolved, StillFiring: true}
+			if writes := e.observe(resolved, at); len(writes) != 2 {
+				t.Fatalf("expected keys that resolved to be reported to both steps, got %d writes", len(writes))
+			}
+		}
+		for _, w := range e.due(at) {
+			counts[w.method]++
+		}
+	}
+	if counts[first] != 1 || counts[second] != 1 {
+		t.Fatalf("expected every step to be notified once, got %d and %d notifications", counts[first], counts[second])
+	}
+
+	e = newEscalator(acks)
+	e.observe(firing(0), now)
+	e.due(now)
+	e.observe(firing(3), now.Add(3*time.Minute))
+	if !e.escalating("fingerprint-0") {
+		t.Fatal("expected earlier fingerprints of the rule to be escalating")
+	}
+	if err := acks.Add(&Ack{Fingerprint: "fingerprint-0", CreatedBy: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	e.observe(firing(6), now.Add(6*time.Minute))
+	if writes := e.due(now.Add(10 * time.Minute)); len(writes) != 0 {
+		t.Fatal("expected the acknowledgement of an earlier fingerprint to stop the escalation")
+	}
+}
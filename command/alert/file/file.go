@@ -0,0 +1,135 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lbzss/elasticsearch-alert/command/alert"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// Ensure AlertMethod adheres to the alert.Method interface
+var _ alert.Method = (*AlertMethod)(nil)
+
+type AlertMethodConfig struct {
+	OutputFilepath string `mapstructure:"file"`
+
+	// AttachmentsDir is the directory the attachments of alerts are
+	// written to, named after the alert's ID, the time it was written and
+	// the attachment. Rules with attachments require it
+	AttachmentsDir string `mapstructure:"attachments_dir"`
+}
+
+// AlertMethod appends every alert as one line of JSON to a file.
+type AlertMethod struct {
+	outputFilepath string
+	attachmentsDir string
+}
+
+type fileAlert struct {
+	ID          string            `json:"id"`
+	Time        time.Time         `json:"@timestamp"`
+	RuleName    string            `json:"rule_name"`
+	State       alert.State       `json:"state"`
+	Severity    string            `json:"severity,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Suppressed  int               `json:"suppressed,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	DiscoverURL string            `json:"discover_url,omitempty"`
+	Records     []*alert.Record   `json:"records,omitempty"`
+	Attachments []string          `json:"attachments,omitempty"`
+}
+
+func NewAlertMethod(config *AlertMethodConfig) (*AlertMethod, error) {
+	if config == nil || config.OutputFilepath == "" {
+		return nil, errors.New("no file path provided")
+	}
+
+	path, err := homedir.Expand(config.OutputFilepath)
+	if err != nil {
+		return nil, fmt.Errorf("error expanding file path: %v", err)
+	}
+
+	var dir string
+	if config.AttachmentsDir != "" {
+		if dir, err = homedir.Expand(config.AttachmentsDir); err != nil {
+			return nil, fmt.Errorf("error expanding attachments dir: %v", err)
+		}
+		dir = filepath.Clean(dir)
+	}
+
+	return &AlertMethod{
+		outputFilepath: filepath.Clean(path),
+		attachmentsDir: dir,
+	}, nil
+}
+
+func (a *AlertMethod) Write(ctx context.Context, alert *alert.Alert) error {
+	now := time.Now()
+	attachments, err := a.writeAttachments(alert, now)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(&fileAlert{
+		ID:          alert.ID,
+		Time:        now,
+		RuleName:    alert.RuleName,
+		State:       alert.State,
+		Severity:    alert.Severity,
+		Labels:      alert.Labels,
+		Annotations: alert.Annotations,
+		Suppressed:  alert.Suppressed,
+		Message:     alert.Message,
+		DiscoverURL: alert.DiscoverURL,
+		Records:     alert.Records,
+		Attachments: attachments,
+	})
+	if err != nil {
+		return fmt.Errorf("error JSON-encoding alert: %v", err)
+	}
+
+	f, err := os.OpenFile(a.outputFilepath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening file %s: %v", a.outputFilepath, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing to file %s: %v", a.outputFilepath, err)
+	}
+	return nil
+}
+
+// writeAttachments writes the attachments of an alert written at time now
+// to the attachments dir and returns their paths. Repeats of an alert
+// have the same ID, so the time keeps them from overwriting each other.
+func (a *AlertMethod) writeAttachments(alert *alert.Alert, now time.Time) ([]string, error) {
+	if len(alert.Attachments) < 1 {
+		return nil, nil
+	}
+	if a.attachmentsDir == "" {
+		return nil, errors.New("alert has attachments but no 'attachments_dir' is configured")
+	}
+
+	if err := os.MkdirAll(a.attachmentsDir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating attachments dir %s: %v", a.attachmentsDir, err)
+	}
+
+	paths := make([]string, 0, len(alert.Attachments))
+	for _, attachment := range alert.Attachments {
+		name := fmt.Sprintf("%s-%s-%s", alert.ID, now.UTC().Format("20060102T150405.000000000Z"), filepath.Base(attachment.Filename))
+		path := filepath.Join(a.attachmentsDir, name)
+		if err := os.WriteFile(path, attachment.Data, 0o644); err != nil {
+			return nil, fmt.Errorf("error writing attachment %s: %v", path, err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
@@ -0,0 +1,38 @@
+package alert
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+// Fingerprint deterministically identifies an alert by its rule name and
+// the set of record keys it reports, regardless of their order or of the
+// document counts. If hitIDs is not empty the '_id's of the matched hits
+// are part of the fingerprint too.
+func Fingerprint(rule string, records []*Record, hitIDs []string) string {
+	keys := make([]string, 0, len(records))
+	for _, record := range records {
+		if len(record.Fields) < 1 {
+			keys = append(keys, record.Filter)
+			continue
+		}
+		for _, field := range record.Fields {
+			keys = append(keys, record.Filter+"\x1f"+field.Key)
+		}
+	}
+	sort.Strings(keys)
+
+	ids := make([]string, len(hitIDs))
+	copy(ids, hitIDs)
+	sort.Strings(ids)
+
+	h := sha256.New()
+	h.Write([]byte(rule))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.Join(keys, "\x1e")))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.Join(ids, "\x1e")))
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
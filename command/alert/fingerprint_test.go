@@ -0,0 +1,70 @@
+package alert
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	a := []*Record{
+		{Filter: "aggregations.hosts.buckets", Fields: []*Field{{Key: "foo", Count: 1}, {Key: "bar", Count: 2}}},
+		{Filter: "hits.hits._source", Text: "{}"},
+	}
+	b := []*Record{
+		{Filter: "hits.hits._source", Text: "{\"changed\": true}"},
+		{Filter: "aggregations.hosts.buckets", Fields: []*Field{{Key: "bar", Count: 20}, {Key: "foo", Count: 10}}},
+	}
+	c := []*Record{
+		{Filter: "aggregations.hosts.buckets", Fields: []*Field{{Key: "foo", Count: 1}}},
+	}
+
+	if Fingerprint("rule", a, nil) != Fingerprint("rule", b, nil) {
+		t.Error("fingerprint should not depend on record order, counts or text")
+	}
+	if Fingerprint("rule", a, nil) == Fingerprint("rule", c, nil) {
+		t.Error("fingerprint should depend on the set of keys")
+	}
+	if Fingerprint("rule", a, nil) == Fingerprint("other", a, nil) {
+		t.Error("fingerprint should depend on the rule name")
+	}
+	if Fingerprint("rule", a, []string{"1", "2"}) != Fingerprint("rule", a, []string{"2", "1"}) {
+		t.Error("fingerprint should not depend on hit order")
+	}
+	if Fingerprint("rule", a, []string{"1"}) == Fingerprint("rule", a, []string{"2"}) {
+		t.Error("fingerprint should depend on hit IDs")
+	}
+}
+
+func TestHandlerDedup(t *testing.T) {
+	h := NewHandler(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	outputCh := make(chan *Alert)
+	go h.Run(ctx, outputCh)
+
+	first, second := new(recordingMethod), new(recordingMethod)
+	alert := func(state State) *Alert {
+		return &Alert{
+			ID:          "fingerprint",
+			RuleName:    "rule",
+			State:       state,
+			Methods:     []Method{first, second},
+			DedupWindow: time.Hour,
+		}
+	}
+	outputCh <- alert(StateFiring)
+	outputCh <- alert(StateFiring)
+	outputCh <- alert(StateResolved)
+	outputCh <- alert(StateFiring)
+	time.Sleep(100 * time.Millisecond)
+
+	cancel()
+	<-h.DoneCh
+	for i, rec := range []*recordingMethod{first, second} {
+		if len(rec.alerts) != 3 || rec.alerts[0].State != StateFiring || rec.alerts[1].State != StateResolved || rec.alerts[2].State != StateFiring {
+			t.Errorf("expected output %d to get the firing alert once, the resolved one and the firing one again, got %d alerts", i+1, len(rec.alerts))
+		}
+	}
+}
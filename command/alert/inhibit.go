@@ -0,0 +1,81 @@
+package alert
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// InhibitRule suppresses alerts matching TargetMatchers while an alert
+// matching SourceMatchers is firing, provided both have the same values
+// for every label in Equal.
+type InhibitRule struct {
+	SourceMatchers []*Matcher
+	TargetMatchers []*Matcher
+	Equal          []string
+}
+
+// Inhibitor keeps the latest firing alert of every rule and decides
+// whether alerts are inhibited by them.
+type Inhibitor struct {
+	rules  []*InhibitRule
+	firing map[string]*Alert
+	lock   *sync.Mutex
+}
+
+func NewInhibitor(rules []*InhibitRule) *Inhibitor {
+	return &Inhibitor{
+		rules:  rules,
+		firing: make(map[string]*Alert),
+		lock:   new(sync.Mutex),
+	}
+}
+
+// Observe records the alert as firing for its rule until it ends, or
+// forgets the rule's firing alert if the alert is resolved.
+func (i *Inhibitor) Observe(a *Alert) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	switch a.State {
+	case StateResolved:
+		delete(i.firing, a.RuleName)
+	default:
+		i.firing[a.RuleName] = a
+	}
+}
+
+// Inhibited returns the firing alert inhibiting a at time now, or nil if
+// there is none. Alerts never inhibit alerts of their own rule.
+func (i *Inhibitor) Inhibited(a *Alert, now time.Time) *Alert {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	for rule, source := range i.firing {
+		if !source.EndsAt.IsZero() && !now.Before(source.EndsAt) {
+			delete(i.firing, rule)
+		}
+	}
+
+	for _, rule := range i.rules {
+		if !MatchAll(rule.TargetMatchers, a) {
+			continue
+		}
+		for _, source := range i.firing {
+			if source.RuleName == a.RuleName {
+				continue
+			}
+			if MatchAll(rule.SourceMatchers, source) && equalValues(rule.Equal, source, a) {
+				return source
+			}
+		}
+	}
+	return nil
+}
+
+func equalValues(names []string, a, b *Alert) bool {
+	for _, name := range names {
+		if strings.Join(a.values(name), ",") != strings.Join(b.values(name), ",") {
+			return false
+		}
+	}
+	return true
+}
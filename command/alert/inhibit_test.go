@@ -0,0 +1,99 @@
+package alert
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInhibitor(t *testing.T) {
+	now := time.Now()
+	i := NewInhibitor([]*InhibitRule{{
+		SourceMatchers: mustMatchers(t, "rule=cluster-unreachable"),
+		TargetMatchers: mustMatchers(t, "severity!=critical"),
+		Equal:          []string{"cluster"},
+	}})
+
+	source := &Alert{
+		RuleName: "cluster-unreachable",
+		State:    StateFiring,
+		Labels:   map[string]string{"cluster": "prod"},
+		EndsAt:   now.Add(time.Hour),
+	}
+	target := &Alert{RuleName: "index-lag", Labels: map[string]string{"cluster": "prod"}}
+	other := &Alert{RuleName: "index-lag", Labels: map[string]string{"cluster": "staging"}}
+	critical := &Alert{RuleName: "disk-full", Labels: map[string]string{"cluster": "prod", "severity": "critical"}}
+	criticalSeverity := &Alert{RuleName: "disk-full", Severity: SeverityCritical, Labels: map[string]string{"cluster": "prod"}}
+
+	if i.Inhibited(target, now) != nil {
+		t.Fatal("nothing should be inhibited before the source fires")
+	}
+
+	i.Observe(source)
+	if i.Inhibited(target, now) != source {
+		t.Fatal("expected target to be inhibited by source")
+	}
+	if i.Inhibited(other, now) != nil {
+		t.Fatal("alerts with different 'equal' labels should not be inhibited")
+	}
+	if i.Inhibited(critical, now) != nil {
+		t.Fatal("alerts not matching the target matchers should not be inhibited")
+	}
+	if i.Inhibited(criticalSeverity, now) != nil {
+		t.Fatal("matchers on severity should match the severity field")
+	}
+	if i.Inhibited(target, now.Add(2*time.Hour)) != nil {
+		t.Fatal("source alerts should stop inhibiting once they end")
+	}
+
+	i.Observe(source)
+	i.Observe(&Alert{RuleName: "cluster-unreachable", State: StateResolved})
+	if i.Inhibited(target, now) != nil {
+		t.Fatal("resolved sources should stop inhibiting")
+	}
+}
+
+func TestHandlerInhibitsWhileThrottled(t *testing.T) {
+	inhibitor := NewInhibitor([]*InhibitRule{{
+		SourceMatchers: mustMatchers(t, "rule=cluster-unreachable"),
+		TargetMatchers: mustMatchers(t, "rule=index-lag"),
+	}})
+	h := NewHandler(&HandlerConfig{Inhibitor: inhibitor})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	outputCh := make(chan *Alert)
+	go h.Run(ctx, outputCh)
+
+	// Every tick the source fires again but is throttled, which must keep
+	// it inhibiting well beyond the two ticks its alerts last
+	const tick = 50 * time.Millisecond
+	sources, targets := new(recordingMethod), new(recordingMethod)
+	source := func(throttled bool) *Alert {
+		return &Alert{
+			ID:        "source",
+			RuleName:  "cluster-unreachable",
+			State:     StateFiring,
+			Methods:   []Method{sources},
+			EndsAt:    time.Now().Add(2 * tick),
+			Throttled: throttled,
+		}
+	}
+
+	outputCh <- source(false)
+	for i := 0; i < 5; i++ {
+		time.Sleep(tick)
+		outputCh <- source(true)
+		outputCh <- &Alert{ID: "target", RuleName: "index-lag", State: StateFiring, Methods: []Method{targets}}
+	}
+	time.Sleep(tick)
+
+	cancel()
+	<-h.DoneCh
+	if len(sources.alerts) != 1 {
+		t.Errorf("expected only the unthrottled source alert to be written, got %d", len(sources.alerts))
+	}
+	if len(targets.alerts) != 0 {
+		t.Errorf("expected targets to be inhibited by the throttled source, got %d writes", len(targets.alerts))
+	}
+}
@@ -18,12 +18,12 @@ func newInventory() *inventory {
 
 func (i *inventory) register(id string) {
 	i.lock.Lock()
+	defer i.lock.Unlock()
 	if _, ok := i.alerts[id]; ok {
 		return
 	}
 
 	i.alerts[id] = defaultNumAttempts
-	i.lock.Unlock()
 }
 
 func (i *inventory) deregister(id string) {
@@ -0,0 +1,82 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// jsonFile persists a value as JSON and tells whether the file has been
+// changed by someone else since it was last read or written.
+type jsonFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// load decodes the file into v if it changed since the last call. It
+// returns whether v was updated; a missing file counts as unchanged
+// unless it existed before, in which case reset is called.
+func (f *jsonFile) load(v interface{}, reset func()) (bool, error) {
+	info, err := os.Stat(f.path)
+	if os.IsNotExist(err) {
+		if !f.modTime.IsZero() {
+			f.modTime = time.Time{}
+			f.size = 0
+			reset()
+			return true, nil
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error reading file %s: %v", f.path, err)
+	}
+
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return false, fmt.Errorf("error reading file %s: %v", f.path, err)
+	}
+
+	reset()
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("error JSON-decoding file %s: %v", f.path, err)
+	}
+
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return true, nil
+}
+
+// save atomically replaces the file with v encoded as JSON.
+func (f *jsonFile) save(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error JSON-encoding %s: %v", f.path, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return fmt.Errorf("error creating directory of file %s: %v", f.path, err)
+	}
+
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("error writing file %s: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		return fmt.Errorf("error writing file %s: %v", f.path, err)
+	}
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("error reading file %s: %v", f.path, err)
+	}
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return nil
+}
@@ -0,0 +1,89 @@
+package alert
+
+import "github.com/lbzss/elasticsearch-alert/utils"
+
+const (
+	// MatcherRule matches against the name of the rule an alert is for
+	MatcherRule = "rule"
+
+	// MatcherKey matches against the bucket keys of an alert's records
+	MatcherKey = "key"
+
+	// MatcherFilter matches against the filters of an alert's records
+	MatcherFilter = "filter"
+
+	// MatcherState matches against the state of an alert
+	MatcherState = "state"
+
+	// MatcherSeverity matches against the severity of an alert, or its
+	// 'severity' label if it has none
+	MatcherSeverity = "severity"
+)
+
+// Matcher selects alerts by one of their properties, see Matcher*
+// constants, or by one of their labels. If an alert has several values
+// for the property, e.g. one per bucket key, it is enough that one of
+// them matches.
+type Matcher struct {
+	utils.Matcher
+}
+
+// ParseMatcher parses a matcher of the form 'name=value', 'name!=value',
+// 'name=~regex' or 'name!~regex'.
+func ParseMatcher(s string) (*Matcher, error) {
+	m, err := utils.ParseMatcher(s)
+	if err != nil {
+		return nil, err
+	}
+	return &Matcher{Matcher: *m}, nil
+}
+
+// Matches reports whether the alert matches.
+func (m *Matcher) Matches(a *Alert) bool {
+	return m.MatchesAny(a.values(m.Name))
+}
+
+// MatchAll reports whether the alert matches every one of matchers.
+func MatchAll(matchers []*Matcher, a *Alert) bool {
+	for _, m := range matchers {
+		if !m.Matches(a) {
+			return false
+		}
+	}
+	return true
+}
+
+// values returns the values of the property name of the alert, falling
+// back to the label name.
+func (a *Alert) values(name string) []string {
+	switch name {
+	case MatcherRule:
+		return []string{a.RuleName}
+	case MatcherState:
+		return []string{string(a.State)}
+	case MatcherSeverity:
+		// Rules that predate the severity field may carry it as a label
+		if a.Severity != "" {
+			return []string{a.Severity}
+		}
+	case MatcherFilter:
+		filters := make([]string, 0, len(a.Records))
+		for _, record := range a.Records {
+			filters = append(filters, record.Filter)
+		}
+		return filters
+	case MatcherKey:
+		keys := make([]string, 0)
+		for _, record := range a.Records {
+			for _, field := range record.Fields {
+				keys = append(keys, field.Key)
+			}
+		}
+		return keys
+	}
+
+	if v, ok := a.Labels[name]; ok {
+		return []string{v}
+	}
+	return nil
+}
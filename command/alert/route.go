@@ -0,0 +1,53 @@
+package alert
+
+// Route is a node of the routing tree that decides which receivers get
+// an alert. An alert descends into the first child route whose matchers
+// it matches, and into further matching siblings as long as the matched
+// route has Continue set. If no child route matches, the alert goes to
+// the node's own receiver. Routes without a receiver inherit the one of
+// their parent.
+type Route struct {
+	Receiver string
+	Matchers []*Matcher
+	Continue bool
+	Routes   []*Route
+}
+
+// Match returns the names of the receivers the alert is routed to. The
+// matchers of the root route are ignored, it matches every alert.
+func (r *Route) Match(a *Alert) []string {
+	receivers := r.match(a, "")
+	seen := make(map[string]struct{})
+	unique := make([]string, 0, len(receivers))
+	for _, receiver := range receivers {
+		if _, ok := seen[receiver]; ok {
+			continue
+		}
+		seen[receiver] = struct{}{}
+		unique = append(unique, receiver)
+	}
+	return unique
+}
+
+func (r *Route) match(a *Alert, parentReceiver string) []string {
+	receiver := r.Receiver
+	if receiver == "" {
+		receiver = parentReceiver
+	}
+
+	receivers := make([]string, 0)
+	for _, child := range r.Routes {
+		if !MatchAll(child.Matchers, a) {
+			continue
+		}
+		receivers = append(receivers, child.match(a, receiver)...)
+		if !child.Continue {
+			break
+		}
+	}
+
+	if len(receivers) < 1 && receiver != "" {
+		receivers = append(receivers, receiver)
+	}
+	return receivers
+}
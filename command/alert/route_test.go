@@ -0,0 +1,53 @@
+package alert
+
+import (
+	"reflect"
+	"testing"
+)
+
+func mustMatchers(t *testing.T, ss ...string) []*Matcher {
+	matchers := make([]*Matcher, 0, len(ss))
+	for _, s := range ss {
+		m, err := ParseMatcher(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers
+}
+
+func TestRoute(t *testing.T) {
+	root := &Route{
+		Receiver: "default",
+		Routes: []*Route{
+			{
+				Matchers: mustMatchers(t, "team=db"),
+				Receiver: "db",
+				Continue: true,
+				Routes: []*Route{
+					{Matchers: mustMatchers(t, "severity=critical"), Receiver: "db-pager"},
+				},
+			},
+			{Matchers: mustMatchers(t, "severity=~critical|error"), Receiver: "pager"},
+			{Matchers: mustMatchers(t, "team=web")},
+		},
+	}
+
+	cases := []struct {
+		labels    map[string]string
+		receivers []string
+	}{
+		{map[string]string{}, []string{"default"}},
+		{map[string]string{"team": "db"}, []string{"db"}},
+		{map[string]string{"team": "db", "severity": "critical"}, []string{"db-pager", "pager"}},
+		{map[string]string{"team": "web", "severity": "error"}, []string{"pager"}},
+		{map[string]string{"team": "web"}, []string{"default"}},
+	}
+	for _, c := range cases {
+		got := root.Match(&Alert{RuleName: "rule", Labels: c.labels})
+		if !reflect.DeepEqual(got, c.receivers) {
+			t.Errorf("labels %v: expected receivers %v, got %v", c.labels, c.receivers, got)
+		}
+	}
+}
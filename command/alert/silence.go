@@ -0,0 +1,268 @@
+package alert
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// silenceRetention is how long expired silences are kept, e.g. for
+// 'silence list', before they are removed from the silences file
+const silenceRetention = 5 * 24 * time.Hour
+
+// Silence mutes the alerts matching all of its matchers between StartsAt
+// and EndsAt. A silence with a 'key' matcher mutes only the fields of the
+// keys it matches.
+type Silence struct {
+	ID        string     `json:"id"`
+	Matchers  []*Matcher `json:"matchers"`
+	StartsAt  time.Time  `json:"starts_at"`
+	EndsAt    time.Time  `json:"ends_at"`
+	CreatedBy string     `json:"created_by"`
+	Comment   string     `json:"comment"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (s *Silence) validate() error {
+	if len(s.Matchers) < 1 {
+		return errors.New("silence must have at least one matcher")
+	}
+
+	for _, m := range s.Matchers {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if !s.EndsAt.After(s.StartsAt) {
+		return errors.New("silence must end after it starts")
+	}
+	return nil
+}
+
+// Active reports whether the silence is in effect at time now.
+func (s *Silence) Active(now time.Time) bool {
+	return !now.Before(s.StartsAt) && now.Before(s.EndsAt)
+}
+
+// Expired reports whether the silence has ended at time now.
+func (s *Silence) Expired(now time.Time) bool {
+	return !now.Before(s.EndsAt)
+}
+
+func (s *Silence) byKey() bool {
+	for _, m := range s.Matchers {
+		if m.Name == MatcherKey {
+			return true
+		}
+	}
+	return false
+}
+
+// SilenceStore holds silences persisted in a JSON file. The file is
+// reloaded whenever it changes so silences managed by another process,
+// e.g. the CLI, take effect without a restart.
+type SilenceStore struct {
+	file     *jsonFile
+	silences []*Silence
+	lock     *sync.Mutex
+}
+
+// OpenSilenceStore loads the silences from the file at path. The file
+// does not need to exist yet.
+func OpenSilenceStore(path string) (*SilenceStore, error) {
+	s := &SilenceStore{
+		file: &jsonFile{path: path},
+		lock: new(sync.Mutex),
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SilenceStore) reload() error {
+	var silences []*Silence
+	changed, err := s.file.load(&silences, func() { silences = nil })
+	if err != nil || !changed {
+		return err
+	}
+
+	for _, silence := range silences {
+		if err := silence.validate(); err != nil {
+			return fmt.Errorf("error in silence %s of file %s: %v", silence.ID, s.file.path, err)
+		}
+	}
+	s.silences = silences
+	return nil
+}
+
+// save persists the silences, dropping those that expired longer than
+// silenceRetention before time now.
+func (s *SilenceStore) save(now time.Time) error {
+	kept := make([]*Silence, 0, len(s.silences))
+	for _, silence := range s.silences {
+		if now.Sub(silence.EndsAt) < silenceRetention {
+			kept = append(kept, silence)
+		}
+	}
+	s.silences = kept
+
+	return s.file.save(s.silences)
+}
+
+// Add validates and persists the silence, assigning it an ID.
+func (s *SilenceStore) Add(silence *Silence) error {
+	if err := silence.validate(); err != nil {
+		return err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Errorf("error generating silence ID: %v", err)
+	}
+	silence.ID = hex.EncodeToString(id)
+	if silence.CreatedAt.IsZero() {
+		silence.CreatedAt = time.Now()
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+	s.silences = append(s.silences, silence)
+	return s.save(time.Now())
+}
+
+// Expire ends the silence with the given ID at time now. Silences that
+// have not started yet are removed.
+func (s *SilenceStore) Expire(id string, now time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+
+	for i, silence := range s.silences {
+		if silence.ID != id {
+			continue
+		}
+		if silence.Expired(now) {
+			return fmt.Errorf("silence %s has already expired", id)
+		}
+		if now.Before(silence.StartsAt) {
+			s.silences = append(s.silences[:i], s.silences[i+1:]...)
+		} else {
+			silence.EndsAt = now
+		}
+		return s.save(now)
+	}
+	return fmt.Errorf("no silence with ID %s", id)
+}
+
+// List returns all silences ordered by their end.
+func (s *SilenceStore) List() ([]*Silence, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	silences := make([]*Silence, len(s.silences))
+	copy(silences, s.silences)
+	sort.Slice(silences, func(i, j int) bool {
+		return silences[i].EndsAt.Before(silences[j].EndsAt)
+	})
+	return silences, nil
+}
+
+// Silenced returns what is left of the alert once the silences active
+// at time now have muted it, or nil and the silence that muted the rest
+// of it if nothing is. Silences with a 'key' matcher remove the fields of the keys
+// they match from the alert's records, the others mute it as a whole. If
+// the silences file can't be reloaded the previously loaded silences are
+// used.
+func (s *SilenceStore) Silenced(a *Alert, now time.Time) (*Alert, *Silence) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.reload(); err != nil {
+		fmt.Println("error reloading silences", "error", err)
+	}
+
+	for _, silence := range s.silences {
+		if !silence.Active(now) {
+			continue
+		}
+		if !silence.byKey() {
+			if MatchAll(silence.Matchers, a) {
+				return nil, silence
+			}
+			continue
+		}
+
+		changed := false
+		records := make([]*Record, 0, len(a.Records))
+		for _, record := range a.Records {
+			r := silenceFields(silence, a, record)
+			if r != record {
+				changed = true
+			}
+			if r != nil {
+				records = append(records, r)
+			}
+		}
+		if !changed {
+			continue
+		}
+		if len(records) < 1 {
+			return nil, silence
+		}
+
+		fmt.Println("alert partially silenced", "rule", a.RuleName, "silence", silence.ID)
+		cp := *a
+		cp.Records = records
+		a = &cp
+	}
+	return a, nil
+}
+
+// silenceFields returns the record without the fields the silence mutes,
+// or nil if it mutes all of them. Every field is matched as if it were
+// the only one of the alert.
+func silenceFields(silence *Silence, a *Alert, record *Record) *Record {
+	view := *a
+	if len(record.Fields) < 1 {
+		view.Records = []*Record{record}
+		if MatchAll(silence.Matchers, &view) {
+			return nil
+		}
+		return record
+	}
+
+	fields := make([]*Field, 0, len(record.Fields))
+	for _, field := range record.Fields {
+		r := *record
+		r.Fields = []*Field{field}
+		view.Records = []*Record{&r}
+		if !MatchAll(silence.Matchers, &view) {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) < 1 {
+		return nil
+	}
+	if len(fields) == len(record.Fields) {
+		return record
+	}
+	r := *record
+	r.Fields = fields
+	return &r
+}
@@ -0,0 +1,152 @@
+package alert
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseMatcher(t *testing.T) {
+	a := &Alert{
+		RuleName: "disk-usage",
+		State:    StateFiring,
+		Records: []*Record{
+			{Filter: "aggregations.hosts.buckets", Fields: []*Field{{Key: "web-1"}, {Key: "db-1"}}},
+		},
+	}
+
+	cases := []struct {
+		matcher string
+		matches bool
+	}{
+		{"rule=disk-usage", true},
+		{"rule!=disk-usage", false},
+		{"rule=~disk-.*", true},
+		{"rule=~disk", false},
+		{"key=db-1", true},
+		{"key!~web-.*", false},
+		{"state=resolved", false},
+		{"filter=aggregations.hosts.buckets", true},
+	}
+	for _, c := range cases {
+		m, err := ParseMatcher(c.matcher)
+		if err != nil {
+			t.Fatalf("%s: %v", c.matcher, err)
+		}
+		if m.Matches(a) != c.matches {
+			t.Errorf("%s: expected match to be %v", c.matcher, c.matches)
+		}
+		if m.String() != c.matcher {
+			t.Errorf("%s: got %s when formatted", c.matcher, m.String())
+		}
+	}
+
+	if _, err := ParseMatcher("rule"); err == nil {
+		t.Error("expected error for matcher without operator")
+	}
+	if _, err := ParseMatcher("rule=~("); err == nil {
+		t.Error("expected error for invalid regular expression")
+	}
+}
+
+func TestSilenceStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "silences.json")
+	store, err := OpenSilenceStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	m, _ := ParseMatcher("rule=disk-usage")
+	silence := &Silence{
+		Matchers:  []*Matcher{m},
+		StartsAt:  now,
+		EndsAt:    now.Add(time.Hour),
+		CreatedBy: "test",
+		Comment:   "maintenance",
+	}
+	if err := store.Add(silence); err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Alert{RuleName: "disk-usage"}
+	if _, s := store.Silenced(a, now.Add(time.Minute)); s == nil {
+		t.Fatal("expected alert to be silenced")
+	}
+	if _, s := store.Silenced(&Alert{RuleName: "other"}, now.Add(time.Minute)); s != nil {
+		t.Fatal("expected alert of other rule not to be silenced")
+	}
+	if _, s := store.Silenced(a, now.Add(2*time.Hour)); s != nil {
+		t.Fatal("expected silence to end")
+	}
+
+	reopened, err := OpenSilenceStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Expire(silence.ID, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, s := store.Silenced(a, now.Add(2*time.Minute)); s != nil {
+		t.Fatal("expected silence expired by another store to be reloaded")
+	}
+
+	old := &Silence{
+		Matchers:  []*Matcher{m},
+		StartsAt:  now.Add(-silenceRetention - 2*time.Hour),
+		EndsAt:    now.Add(-silenceRetention - time.Hour),
+		CreatedBy: "test",
+		Comment:   "maintenance",
+	}
+	if err := store.Add(old); err != nil {
+		t.Fatal(err)
+	}
+	silences, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(silences) != 1 || silences[0].ID != silence.ID {
+		t.Fatalf("expected silences to be removed once expired for longer than their retention, got %d silences", len(silences))
+	}
+}
+
+func TestSilenceStoreKeys(t *testing.T) {
+	store, err := OpenSilenceStore(filepath.Join(t.TempDir(), "silences.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	rule, _ := ParseMatcher("rule=disk-usage")
+	key, _ := ParseMatcher("key=web-1")
+	if err := store.Add(&Silence{
+		Matchers:  []*Matcher{rule, key},
+		StartsAt:  now,
+		EndsAt:    now.Add(time.Hour),
+		CreatedBy: "test",
+		Comment:   "maintenance of web-1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Alert{RuleName: "disk-usage", Records: []*Record{
+		{Filter: "aggregations.hosts.buckets", Fields: []*Field{{Key: "web-1"}, {Key: "web-2"}}},
+	}}
+	left, silence := store.Silenced(a, now)
+	if silence != nil || left == nil {
+		t.Fatal("expected alerts with other keys not to be silenced")
+	}
+	if fields := left.Records[0].Fields; len(fields) != 1 || fields[0].Key != "web-2" {
+		t.Fatalf("expected the silenced key to be removed, got %+v", fields)
+	}
+	if len(a.Records[0].Fields) != 2 {
+		t.Fatal("the original alert must not be modified")
+	}
+
+	only := &Alert{RuleName: "disk-usage", Records: []*Record{
+		{Filter: "aggregations.hosts.buckets", Fields: []*Field{{Key: "web-1"}}},
+	}}
+	if left, silence := store.Silenced(only, now); left != nil || silence == nil {
+		t.Fatal("expected alerts with only silenced keys to be silenced")
+	}
+}
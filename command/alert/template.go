@@ -0,0 +1,255 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	TemplateFormatText = "text"
+	TemplateFormatHTML = "html"
+
+	// templateLibraryPattern matches the files of a template library
+	templateLibraryPattern = "*.tmpl"
+)
+
+// TemplateFuncs are the helper functions available to message templates.
+var TemplateFuncs = map[string]interface{}{
+	"humanize":   humanize,
+	"truncate":   truncate,
+	"toJSON":     toJSON,
+	"formatTime": formatTime,
+	"table":      table,
+	"join":       strings.Join,
+	"upper":      func(v interface{}) string { return strings.ToUpper(fmt.Sprint(v)) },
+	"lower":      func(v interface{}) string { return strings.ToLower(fmt.Sprint(v)) },
+}
+
+// TemplateLibrary holds templates shared by all rules and outputs, which
+// can be invoked from their templates by file name, e.g.
+// '{{ template "slack.tmpl" . }}'.
+type TemplateLibrary struct {
+	text *template.Template
+	html *htmltemplate.Template
+}
+
+// LoadTemplateLibrary parses every '*.tmpl' file in dir.
+func LoadTemplateLibrary(dir string) (*TemplateLibrary, error) {
+	lib := &TemplateLibrary{
+		text: template.New("").Funcs(TemplateFuncs),
+		html: htmltemplate.New("").Funcs(TemplateFuncs),
+	}
+	if dir == "" {
+		return lib, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, templateLibraryPattern))
+	if err != nil {
+		return nil, fmt.Errorf("error globbing templates dir: %v", err)
+	}
+	if len(files) < 1 {
+		return lib, nil
+	}
+
+	if _, err := lib.text.ParseFiles(files...); err != nil {
+		return nil, fmt.Errorf("error parsing templates: %v", err)
+	}
+	if _, err := lib.html.ParseFiles(files...); err != nil {
+		return nil, fmt.Errorf("error parsing templates: %v", err)
+	}
+	return lib, nil
+}
+
+// Template renders the message of an alert. The alert is the data the
+// template is executed with, so e.g. '{{ .RuleName }}', '{{ .Records }}'
+// and '{{ .Hits }}' are available.
+type Template struct {
+	text *template.Template
+	html *htmltemplate.Template
+}
+
+// NewTemplate parses text in the given format, 'text' (default) or
+// 'html', on top of the library. If text is empty the library template
+// called name is used instead.
+func (l *TemplateLibrary) NewTemplate(text, name, format string) (*Template, error) {
+	if text == "" && name == "" {
+		return nil, errors.New("either a template text or the name of a library template must be given")
+	}
+
+	switch format {
+	case "", TemplateFormatText:
+		t, err := l.text.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if text != "" {
+			t, err = t.New("message").Parse(text)
+		} else if t = t.Lookup(name); t == nil {
+			err = fmt.Errorf("no template %q in the template library", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &Template{text: t}, nil
+	case TemplateFormatHTML:
+		t, err := l.html.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if text != "" {
+			t, err = t.New("message").Parse(text)
+		} else if t = t.Lookup(name); t == nil {
+			err = fmt.Errorf("no template %q in the template library", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &Template{html: t}, nil
+	default:
+		return nil, fmt.Errorf("unknown template format %q, expected 'text' or 'html'", format)
+	}
+}
+
+// Execute renders the template with the alert.
+func (t *Template) Execute(a *Alert) (string, error) {
+	var buf bytes.Buffer
+	var err error
+	if t.html != nil {
+		err = t.html.Execute(&buf, a)
+	} else {
+		err = t.text.Execute(&buf, a)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error executing template: %v", err)
+	}
+	return buf.String(), nil
+}
+
+type templatedMethod struct {
+	method   Method
+	template *Template
+}
+
+// Templated wraps method so that the alerts written to it carry a
+// message rendered with tmpl, replacing the rule's message.
+func Templated(method Method, tmpl *Template) Method {
+	return &templatedMethod{
+		method:   method,
+		template: tmpl,
+	}
+}
+
+func (t *templatedMethod) Write(ctx context.Context, a *Alert) error {
+	// Like for rule templates, an alert without the output's message is
+	// better than no alert at all
+	msg, err := t.template.Execute(a)
+	if err != nil {
+		fmt.Println("error rendering output message", "rule", a.RuleName, "error", err)
+		return t.method.Write(ctx, a)
+	}
+
+	cp := *a
+	cp.Message = msg
+	return t.method.Write(ctx, &cp)
+}
+
+// humanize formats a number with thousands separators, e.g. 1234567.891
+// becomes '1,234,567.89'.
+func humanize(v interface{}) (string, error) {
+	var d decimal.Decimal
+	switch n := v.(type) {
+	case int:
+		d = decimal.NewFromInt(int64(n))
+	case int64:
+		d = decimal.NewFromInt(n)
+	case float64:
+		d = decimal.NewFromFloat(n)
+	case json.Number:
+		var err error
+		if d, err = decimal.NewFromString(n.String()); err != nil {
+			return "", err
+		}
+	case string:
+		var err error
+		if d, err = decimal.NewFromString(n); err != nil {
+			return "", err
+		}
+	default:
+		return fmt.Sprint(v), nil
+	}
+
+	s := d.Round(2).String()
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	frac := ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		s, frac = s[:i], s[i:]
+	}
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return sign + s + frac, nil
+}
+
+// truncate shortens s to at most n runes, ending it with '...' if it was
+// cut.
+func truncate(n int, s string) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	if n <= 3 {
+		return string(r[:n])
+	}
+	return string(r[:n-3]) + "..."
+}
+
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// formatTime formats a time.Time, or a string in RFC3339 format, with the
+// given Go time layout.
+func formatTime(layout string, v interface{}) (string, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t.Format(layout), nil
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return "", err
+		}
+		return parsed.Format(layout), nil
+	default:
+		return "", fmt.Errorf("cannot format %T as time", v)
+	}
+}
+
+// table renders fields as an aligned plain text table of keys and counts.
+func table(fields []*Field) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tCOUNT")
+	for _, field := range fields {
+		fmt.Fprintf(w, "%s\t%d\n", field.Key, field.Count)
+	}
+	w.Flush()
+	return buf.String()
+}
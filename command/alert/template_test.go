@@ -0,0 +1,105 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplate(t *testing.T) {
+	dir := t.TempDir()
+	lib := `{{ define "header" }}[{{ .State | upper }}] {{ .RuleName }}{{ end }}`
+	if err := os.WriteFile(filepath.Join(dir, "common.tmpl"), []byte(lib), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := LoadTemplateLibrary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Alert{
+		RuleName: "errors",
+		State:    StateFiring,
+		Records: []*Record{
+			{Filter: "aggregations.hosts.buckets", Fields: []*Field{{Key: "web-1", Count: 1234567}}},
+		},
+		Hits: []map[string]interface{}{
+			{"_source": map[string]interface{}{"message": "connection refused by upstream"}},
+		},
+	}
+
+	tmpl, err := templates.NewTemplate(`{{ template "header" . }}
+{{ range .Records }}{{ range .Fields }}{{ .Key }}: {{ humanize .Count }}{{ end }}{{ end }}
+{{ range .Hits }}{{ truncate 13 (index . "_source" "message") }}{{ end }}`, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := tmpl.Execute(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[FIRING] errors\nweb-1: 1,234,567\nconnection..."
+	if msg != expected {
+		t.Fatalf("expected message %q, got %q", expected, msg)
+	}
+
+	html, err := templates.NewTemplate(`<b>{{ .RuleName }}</b>`, "", TemplateFormatHTML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err = html.Execute(&Alert{RuleName: "<script>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(msg, "<script>") {
+		t.Fatalf("expected HTML templates to escape values, got %q", msg)
+	}
+
+	if _, err := templates.NewTemplate("", "missing.tmpl", ""); err == nil {
+		t.Fatal("expected error for unknown library template")
+	}
+
+	rec := new(recordingMethod)
+	if err := Templated(rec, tmpl).Write(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+	if rec.alerts[0].Message != expected || a.Message != "" {
+		t.Fatal("expected the written alert to carry the rendered message without changing the original")
+	}
+
+	broken, err := templates.NewTemplate(`{{ humanize .RuleName }}`, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Message = "rule message"
+	if err := Templated(rec, broken).Write(context.Background(), a); err != nil {
+		t.Fatalf("template errors should not fail the write: %v", err)
+	}
+	if len(rec.alerts) != 2 || rec.alerts[1].Message != "rule message" {
+		t.Fatal("expected the alert to be written unmodified if the template fails")
+	}
+}
+
+func TestHumanize(t *testing.T) {
+	cases := map[interface{}]string{
+		0:                      "0",
+		999:                    "999",
+		-1234:                  "-1,234",
+		1234567.891:            "1,234,567.89",
+		json.Number("1000000"): "1,000,000",
+	}
+	for in, expected := range cases {
+		got, err := humanize(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != expected {
+			t.Errorf("humanize(%v): expected %q, got %q", in, expected, got)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package alert
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Throttle suppresses repeat alerts for the same key within a window
+// and keeps count of how many were suppressed so the next alert that
+// does get through can report them.
+type Throttle struct {
+	window  time.Duration
+	entries map[string]*throttleEntry
+	pruned  time.Time
+	lock    *sync.Mutex
+}
+
+type throttleEntry struct {
+	last       time.Time
+	suppressed int
+}
+
+func NewThrottle(window time.Duration) *Throttle {
+	return &Throttle{
+		window:  window,
+		entries: make(map[string]*throttleEntry),
+		lock:    new(sync.Mutex),
+	}
+}
+
+// Suppress reports whether an alert for key at time now falls within
+// the window of the last alert sent for that key. Suppressed alerts
+// are counted.
+func (t *Throttle) Suppress(key string, now time.Time) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	e, ok := t.entries[key]
+	if !ok || e.last.IsZero() || now.Sub(e.last) >= t.window {
+		return false
+	}
+	e.suppressed++
+	return true
+}
+
+// Suppressed returns the number of alerts suppressed for key since
+// the last one was sent.
+func (t *Throttle) Suppressed(key string) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if e, ok := t.entries[key]; ok {
+		return e.suppressed
+	}
+	return 0
+}
+
+// Sent records that an alert for key was sent at time now, opening a
+// new window and resetting the suppressed count. Keys whose window has
+// passed are forgotten, along with the alerts suppressed for them, so
+// that keys which stopped alerting don't accumulate.
+func (t *Throttle) Sent(key string, now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if now.Sub(t.pruned) >= t.window {
+		for k, e := range t.entries {
+			if now.Sub(e.last) >= t.window {
+				delete(t.entries, k)
+			}
+		}
+		t.pruned = now
+	}
+	t.entries[key] = &throttleEntry{last: now}
+}
+
+// Reset forgets key, so that the next alert for it is not suppressed,
+// e.g. once the condition it reports on has resolved.
+func (t *Throttle) Reset(key string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.entries, key)
+}
+
+// ResetAll forgets all keys.
+func (t *Throttle) ResetAll() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.entries = make(map[string]*throttleEntry)
+}
+
+type throttledMethod struct {
+	method   Method
+	throttle *Throttle
+}
+
+// Throttled wraps method so that it writes at most one firing alert per
+// rule within interval. Alerts written after a quiet period carry the
+// number of alerts suppressed in the meantime. Resolved alerts are never
+// throttled and open the way for the next firing alert.
+func Throttled(method Method, interval time.Duration) Method {
+	return &throttledMethod{
+		method:   method,
+		throttle: NewThrottle(interval),
+	}
+}
+
+func (t *throttledMethod) Write(ctx context.Context, a *Alert) error {
+	if a.State == StateResolved {
+		if err := t.method.Write(ctx, a); err != nil {
+			return err
+		}
+		t.throttle.Reset(a.RuleName)
+		return nil
+	}
+
+	now := time.Now()
+	if t.throttle.Suppress(a.RuleName, now) {
+		return nil
+	}
+
+	cp := *a
+	cp.Suppressed += t.throttle.Suppressed(a.RuleName)
+	if err := t.method.Write(ctx, &cp); err != nil {
+		return err
+	}
+	t.throttle.Sent(a.RuleName, now)
+	return nil
+}
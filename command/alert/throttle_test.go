@@ -0,0 +1,102 @@
+package alert
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type recordingMethod struct {
+	alerts []*Alert
+	err    error
+}
+
+func (r *recordingMethod) Write(_ context.Context, a *Alert) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+func TestThrottle(t *testing.T) {
+	now := time.Now()
+	th := NewThrottle(time.Minute)
+
+	if th.Suppress("a", now) {
+		t.Fatal("first alert for a key should not be suppressed")
+	}
+	th.Sent("a", now)
+
+	for i := 1; i <= 3; i++ {
+		if !th.Suppress("a", now.Add(time.Duration(i)*10*time.Second)) {
+			t.Fatalf("alert %d within the window should be suppressed", i)
+		}
+	}
+	if n := th.Suppressed("a"); n != 3 {
+		t.Fatalf("expected 3 suppressed alerts, got %d", n)
+	}
+
+	if th.Suppress("b", now) {
+		t.Fatal("keys should be throttled independently")
+	}
+
+	later := now.Add(time.Minute)
+	if th.Suppress("a", later) {
+		t.Fatal("alert after the window should not be suppressed")
+	}
+	th.Sent("a", later)
+	if n := th.Suppressed("a"); n != 0 {
+		t.Fatalf("suppressed count should reset once sent, got %d", n)
+	}
+
+	th.Reset("a")
+	if th.Suppress("a", later) {
+		t.Fatal("alerts for a key that was reset should not be suppressed")
+	}
+
+	th.Sent("b", now)
+	th.Sent("a", later.Add(time.Minute))
+	if _, ok := th.entries["b"]; ok {
+		t.Fatal("keys whose window has passed should be forgotten")
+	}
+}
+
+func TestThrottledMethod(t *testing.T) {
+	rec := new(recordingMethod)
+	m := Throttled(rec, time.Hour)
+
+	a := &Alert{RuleName: "rule"}
+	for i := 0; i < 3; i++ {
+		if err := m.Write(context.Background(), a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(rec.alerts) != 1 {
+		t.Fatalf("expected 1 alert to be written, got %d", len(rec.alerts))
+	}
+
+	if err := m.Write(context.Background(), &Alert{RuleName: "rule", State: StateResolved}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Write(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.alerts) != 3 {
+		t.Fatalf("expected the rule to alert again once resolved, got %d alerts", len(rec.alerts))
+	}
+
+	failing := &recordingMethod{err: errors.New("unavailable")}
+	m = Throttled(failing, time.Hour)
+	if err := m.Write(context.Background(), a); err == nil {
+		t.Fatal("expected error to be returned")
+	}
+	failing.err = nil
+	if err := m.Write(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+	if len(failing.alerts) != 1 {
+		t.Fatal("a failed write should not open a throttle window")
+	}
+}
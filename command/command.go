@@ -0,0 +1,52 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const usage = `Usage: elasticsearch-alert <command> [options]
+
+Commands:
+    run        Run the alerting daemon (default)
+    silence    Manage silences (add, list, expire)
+    ack        Acknowledge alerts to stop their escalation
+    explain    Dry-run rules and explain how their conditions evaluate
+`
+
+// Run executes the command given by args and returns the exit code.
+func Run(args []string) int {
+	if len(args) < 1 {
+		return runDaemon()
+	}
+
+	switch args[0] {
+	case "run":
+		return runDaemon()
+	case "silence":
+		return runSilence(args[1:])
+	case "ack":
+		return runAck(args[1:])
+	case "explain":
+		return runExplain(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 1
+	}
+}
+
+// stringsFlag is a flag.Value collecting every occurrence of a flag
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
@@ -0,0 +1,106 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lbzss/elasticsearch-alert/command/query"
+	"github.com/lbzss/elasticsearch-alert/config"
+)
+
+const explainUsage = `Usage: elasticsearch-alert explain [options]
+
+Runs the queries of the configured rules once and prints, as JSON, how
+their conditions were evaluated and what their alerts would report.
+No alerts are sent.
+
+Options:
+`
+
+func runExplain(args []string) int {
+	var (
+		rules   stringsFlag
+		timeout time.Duration
+	)
+	flags := flag.NewFlagSet("explain", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), explainUsage)
+		flags.PrintDefaults()
+	}
+	flags.Var(&rules, "rule", "name of a rule to explain, may be repeated (default: all rules)")
+	flags.DurationVar(&timeout, "timeout", 30*time.Second, "timeout of each rule's query")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 1
+	}
+
+	cfg, err := config.ParseConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error parsing configuration: %v\n", err)
+		return 1
+	}
+
+	client, err := cfg.NewESClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating Elasticsearch client: %v\n", err)
+		return 1
+	}
+
+	selected := make(map[string]bool, len(rules))
+	for _, name := range rules {
+		selected[name] = true
+	}
+
+	explanations := make([]*query.Explanation, 0, len(cfg.Rules))
+	for _, rule := range cfg.Rules {
+		if len(selected) > 0 && !selected[rule.Name] {
+			continue
+		}
+		delete(selected, rule.Name)
+
+		handler, err := query.NewQueryHandler(&query.QueryHandlerConfig{
+			Name:       rule.Name,
+			Client:     client,
+			ESUrl:      cfg.Elasticsearch.Server.ElasticsearchURL,
+			QueryData:  rule.ElasticsearchBody,
+			QueryIndex: rule.ElasticsearchIndex,
+			Schedule:   rule.CronSchedule,
+			BodyField:  rule.BodyField,
+			Filters:    rule.Filters,
+			Conditions: rule.Conditions,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error creating query handler for rule %s: %v\n", rule.Name, err)
+			return 1
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		explanation, err := handler.Explain(ctx)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error explaining rule %s: %v\n", rule.Name, err)
+			return 1
+		}
+		explanations = append(explanations, explanation)
+	}
+
+	for name := range selected {
+		fmt.Fprintf(os.Stderr, "no rule named %q\n", name)
+		return 1
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(explanations); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,114 @@
+package command
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lbzss/elasticsearch-alert/command/alert"
+	"github.com/lbzss/elasticsearch-alert/config"
+)
+
+const acksPath = "/api/v1/acks"
+
+// newHTTPServer creates the server of the HTTP API. It serves
+//
+//	GET  /api/v1/acks                list acknowledgements
+//	POST /api/v1/acks/<fingerprint>  acknowledge an alert, with an optional
+//	                                 JSON body {"created_by": "", "comment": ""}
+//
+// If the configuration has a token, every request must carry it as a
+// bearer token. Only alerts for which escalating returns true can be
+// acknowledged.
+func newHTTPServer(cfg *config.HTTPConfig, acks *alert.AckStore, escalating func(string) bool) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(acksPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		list, err := acks.List()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+	mux.HandleFunc(acksPath+"/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		fingerprint := strings.TrimPrefix(r.URL.Path, acksPath+"/")
+		if fingerprint == "" || strings.Contains(fingerprint, "/") {
+			writeJSONError(w, http.StatusNotFound, "no fingerprint provided")
+			return
+		}
+		if !escalating(fingerprint) {
+			writeJSONError(w, http.StatusNotFound, "no alert with fingerprint "+fingerprint+" is being escalated")
+			return
+		}
+
+		ack := &alert.Ack{
+			Fingerprint: fingerprint,
+			CreatedBy:   "api",
+		}
+		if r.ContentLength != 0 {
+			var body struct {
+				CreatedBy string `json:"created_by"`
+				Comment   string `json:"comment"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "error JSON-decoding request body: "+err.Error())
+				return
+			}
+			if body.CreatedBy != "" {
+				ack.CreatedBy = body.CreatedBy
+			}
+			ack.Comment = body.Comment
+		}
+
+		if err := acks.Add(ack); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, ack)
+	})
+
+	var handler http.Handler = mux
+	if cfg.Token != "" {
+		handler = requireToken(cfg.Token, mux)
+	}
+	return &http.Server{
+		Addr:              cfg.Address,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// requireToken rejects requests without the bearer token.
+func requireToken(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,49 @@
+package command
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/lbzss/elasticsearch-alert/command/alert"
+	"github.com/lbzss/elasticsearch-alert/config"
+)
+
+func TestHTTPServerAcks(t *testing.T) {
+	acks, err := alert.OpenAckStore(filepath.Join(t.TempDir(), "acks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	escalating := func(fingerprint string) bool { return fingerprint == "firing" }
+	server := newHTTPServer(&config.HTTPConfig{Address: ":9100", Token: "secret"}, acks, escalating)
+
+	cases := []struct {
+		path   string
+		token  string
+		status int
+	}{
+		{"/api/v1/acks/firing", "", http.StatusUnauthorized},
+		{"/api/v1/acks/firing", "wrong", http.StatusUnauthorized},
+		{"/api/v1/acks/unknown", "secret", http.StatusNotFound},
+		{"/api/v1/acks/firing", "secret", http.StatusOK},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodPost, c.path, nil)
+		if c.token != "" {
+			r.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		server.Handler.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("expected status %d for %s with token %q, got %d", c.status, c.path, c.token, w.Code)
+		}
+	}
+
+	if acks.Acked("unknown") != nil {
+		t.Error("alerts that are not escalated should not be acknowledged")
+	}
+	if acks.Acked("firing") == nil {
+		t.Error("expected the escalated alert to be acknowledged")
+	}
+}
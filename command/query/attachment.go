@@ -0,0 +1,111 @@
+package query
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/lbzss/elasticsearch-alert/command/alert"
+	"github.com/lbzss/elasticsearch-alert/utils"
+)
+
+const (
+	AttachmentFormatCSV    = "csv"
+	AttachmentFormatNDJSON = "ndjson"
+)
+
+// AttachmentConfig renders the hits of a rule into a file attached to
+// its alerts instead of into the text of the body field record.
+type AttachmentConfig struct {
+	// Format is either 'csv' or 'ndjson'
+	Format string
+
+	// Columns are the fields of the hits written to a CSV file. Fields
+	// starting with an underscore, e.g. '_id', are read from the hit
+	// itself, all others from its '_source'
+	Columns []string
+
+	// Filename is the name of the attached file
+	Filename string
+}
+
+func (a *AttachmentConfig) filename() string {
+	if a.Filename != "" {
+		return a.Filename
+	}
+	return "hits." + a.Format
+}
+
+func (a *AttachmentConfig) render(hits []map[string]interface{}) (*alert.Attachment, error) {
+	var buf bytes.Buffer
+	var contentType string
+	switch a.Format {
+	case AttachmentFormatCSV:
+		contentType = "text/csv"
+		w := csv.NewWriter(&buf)
+		if err := w.Write(a.Columns); err != nil {
+			return nil, err
+		}
+		for _, hit := range hits {
+			row := make([]string, 0, len(a.Columns))
+			for _, column := range a.Columns {
+				row = append(row, hitColumn(hit, column))
+			}
+			if err := w.Write(row); err != nil {
+				return nil, err
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return nil, err
+		}
+	case AttachmentFormatNDJSON:
+		contentType = "application/x-ndjson"
+		enc := json.NewEncoder(&buf)
+		for _, hit := range hits {
+			if err := enc.Encode(hit); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown attachment format %q", a.Format)
+	}
+
+	return &alert.Attachment{
+		Filename:    a.filename(),
+		ContentType: contentType,
+		Data:        buf.Bytes(),
+	}, nil
+}
+
+// hitColumn returns the value of a column of a hit as a string. Columns
+// not starting with an underscore are looked up in the hit's '_source',
+// or in the hit itself if it is a source already, see utils.Lookup.
+func hitColumn(hit map[string]interface{}, column string) string {
+	doc := hit
+	if !strings.HasPrefix(column, "_") {
+		if source, ok := hit["_source"].(map[string]interface{}); ok {
+			doc = source
+		}
+	}
+
+	v, ok := utils.Lookup(doc, column)
+	if !ok || v == nil {
+		return ""
+	}
+
+	switch t := v.(type) {
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	default:
+		data, err := json.Marshal(t)
+		if err != nil {
+			return fmt.Sprint(t)
+		}
+		return string(data)
+	}
+}
@@ -0,0 +1,61 @@
+package query
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/lbzss/elasticsearch-alert/command/alert"
+)
+
+func TestAttachmentRender(t *testing.T) {
+	var hits []map[string]interface{}
+	data := `[
+		{"_id": "1", "_index": "logs", "_source": {"host": {"name": "web-1"}, "status": 500, "message": "boom, again"}},
+		{"_id": "2", "_index": "logs", "_source": {"host.name": "web-2", "status": 502}}
+	]`
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&hits); err != nil {
+		t.Fatal(err)
+	}
+
+	csv := &AttachmentConfig{Format: AttachmentFormatCSV, Columns: []string{"_id", "host.name", "status", "message"}}
+	a, err := csv.render(hits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "_id,host.name,status,message\n1,web-1,500,\"boom, again\"\n2,web-2,502,\n"
+	if string(a.Data) != expected {
+		t.Fatalf("unexpected CSV:\n%s\nexpected:\n%s", a.Data, expected)
+	}
+	if a.Filename != "hits.csv" || a.ContentType != "text/csv" {
+		t.Fatalf("unexpected attachment %q (%s)", a.Filename, a.ContentType)
+	}
+
+	ndjson := &AttachmentConfig{Format: AttachmentFormatNDJSON, Filename: "errors.ndjson"}
+	a, err = ndjson.render(hits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(a.Data)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], `{"_id":"1"`) {
+		t.Fatalf("unexpected NDJSON:\n%s", a.Data)
+	}
+	if a.Filename != "errors.ndjson" {
+		t.Fatalf("unexpected filename %q", a.Filename)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	body := &alert.Record{Filter: "hits.hits", Text: "{...}", BodyField: true}
+	a := &alert.Alert{Records: []*alert.Record{{Filter: "aggregations.hosts.buckets"}, body}}
+
+	attach(a, &alert.Attachment{Filename: "hits.csv"}, 2)
+	if len(a.Attachments) != 1 || a.Records[1].Text != "2 hits attached as hits.csv" {
+		t.Fatalf("expected the body field record to refer to the attachment, got %q", a.Records[1].Text)
+	}
+	if body.Text != "{...}" || a.Records[0].Text != "" {
+		t.Fatal("only a copy of the body field record should be changed")
+	}
+}
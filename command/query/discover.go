@@ -0,0 +1,140 @@
+package query
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lbzss/elasticsearch-alert/command/alert"
+	"github.com/lbzss/elasticsearch-alert/utils"
+)
+
+// risonURLSafe leaves the Rison characters unescaped in Discover URLs to
+// keep them readable, and escapes spaces as '%20' rather than '+'
+var risonURLSafe = strings.NewReplacer(
+	"%28", "(", "%29", ")", "%21", "!", "%27", "'",
+	"%3A", ":", "%2C", ",", "%2A", "*", "%40", "@", "%24", "$",
+	"+", "%20",
+)
+
+// discoverLinker builds Kibana Discover URLs reproducing a rule's query.
+type discoverLinker struct {
+	baseURL    string
+	dataViewID string
+	query      interface{}
+
+	// aggFields maps the names of the query's bucket aggregations to the
+	// fields they aggregate on
+	aggFields map[string]string
+}
+
+func newDiscoverLinker(baseURL, dataViewID string, queryData map[string]interface{}) *discoverLinker {
+	d := &discoverLinker{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		dataViewID: dataViewID,
+		query:      queryData["query"],
+		aggFields:  make(map[string]string),
+	}
+	d.collectAggFields(queryData)
+	return d
+}
+
+func (d *discoverLinker) collectAggFields(body map[string]interface{}) {
+	for _, key := range []string{"aggs", "aggregations"} {
+		aggs, ok := body[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for name, raw := range aggs {
+			agg, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for _, v := range agg {
+				if def, ok := v.(map[string]interface{}); ok {
+					if field, ok := def["field"].(string); ok {
+						d.aggFields[name] = field
+					}
+				}
+			}
+			d.collectAggFields(agg)
+		}
+	}
+}
+
+// link returns the URL of Discover showing the documents matched by the
+// rule's query between from and to, narrowed down to the documents with
+// field equal to value if field is not empty.
+func (d *discoverLinker) link(from, to time.Time, field, value string) string {
+	filters := make([]interface{}, 0, 2)
+	if d.query != nil {
+		filters = append(filters, map[string]interface{}{
+			"meta": map[string]interface{}{
+				"alias":    "rule query",
+				"disabled": false,
+				"negate":   false,
+				"type":     "custom",
+				"index":    d.dataViewID,
+			},
+			"query": d.query,
+		})
+	}
+	if field != "" {
+		filters = append(filters, map[string]interface{}{
+			"meta": map[string]interface{}{
+				"key":      field,
+				"params":   map[string]interface{}{"query": value},
+				"disabled": false,
+				"negate":   false,
+				"type":     "phrase",
+				"index":    d.dataViewID,
+			},
+			"query": map[string]interface{}{
+				"match_phrase": map[string]interface{}{field: value},
+			},
+		})
+	}
+
+	g := map[string]interface{}{
+		"time": map[string]interface{}{
+			"from": from.UTC().Format(time.RFC3339),
+			"to":   to.UTC().Format(time.RFC3339),
+		},
+	}
+	a := map[string]interface{}{
+		"index":   d.dataViewID,
+		"filters": filters,
+		"query": map[string]interface{}{
+			"language": "kuery",
+			"query":    "",
+		},
+	}
+
+	return d.baseURL + "/app/discover#/?_g=" + risonURLSafe.Replace(url.QueryEscape(utils.Rison(g))) +
+		"&_a=" + risonURLSafe.Replace(url.QueryEscape(utils.Rison(a)))
+}
+
+// bucketField returns the field aggregated on by the buckets a filter
+// selects. Only filters selecting the buckets of a single, top level
+// aggregation qualify, as bucket keys of nested aggregations are chained.
+func (d *discoverLinker) bucketField(filter string) string {
+	parts := strings.Split(filter, ".")
+	if len(parts) != 3 || parts[0] != "aggregations" || parts[2] != "buckets" {
+		return ""
+	}
+	return d.aggFields[parts[1]]
+}
+
+// annotate sets the Discover URLs of an alert and of its bucket fields.
+func (d *discoverLinker) annotate(a *alert.Alert, from, to time.Time) {
+	a.DiscoverURL = d.link(from, to, "", "")
+	for _, record := range a.Records {
+		field := d.bucketField(record.Filter)
+		if field == "" {
+			continue
+		}
+		for _, f := range record.Fields {
+			f.DiscoverURL = d.link(from, to, field, f.Key)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package query
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbzss/elasticsearch-alert/command/alert"
+)
+
+func TestDiscoverLinker(t *testing.T) {
+	queryData := map[string]interface{}{
+		"query": map[string]interface{}{
+			"term": map[string]interface{}{"level": "error"},
+		},
+		"aggs": map[string]interface{}{
+			"hosts": map[string]interface{}{
+				"terms": map[string]interface{}{"field": "host.name"},
+			},
+		},
+	}
+	d := newDiscoverLinker("https://kibana.example.com/", "logs-*", queryData)
+
+	from := time.Date(2022, 11, 1, 10, 0, 0, 0, time.UTC)
+	a := &alert.Alert{
+		Records: []*alert.Record{
+			{Filter: "aggregations.hosts.buckets", Fields: []*alert.Field{{Key: "web-1", Count: 3}}},
+		},
+	}
+	d.annotate(a, from, from.Add(5*time.Minute))
+
+	for _, s := range []string{
+		"https://kibana.example.com/app/discover#/?_g=",
+		"time:(from:'2022-11-01T10:00:00Z',to:'2022-11-01T10:05:00Z')",
+		"index:'logs-*'",
+		"alias:'rule%20query'",
+		"query:(term:(level:error))",
+	} {
+		if !strings.Contains(a.DiscoverURL, s) {
+			t.Errorf("expected %s to contain %s", a.DiscoverURL, s)
+		}
+	}
+
+	link := a.Records[0].Fields[0].DiscoverURL
+	if !strings.Contains(link, "match_phrase:(host.name:web-1)") {
+		t.Errorf("expected %s to filter on the bucket key", link)
+	}
+}
@@ -0,0 +1,48 @@
+package query
+
+import (
+	"context"
+
+	"github.com/lbzss/elasticsearch-alert/command/alert"
+	"github.com/lbzss/elasticsearch-alert/config"
+)
+
+// Explanation is the outcome of a dry run of a rule.
+type Explanation struct {
+	Rule string `json:"rule"`
+
+	// Met is whether the rule's conditions are met. Conditions explains
+	// how each of them was evaluated
+	Met        bool            `json:"met"`
+	Conditions []*config.Trace `json:"conditions,omitempty"`
+
+	// Records are what an alert of this run would report. There are none
+	// if the conditions are not met
+	Records []*alert.Record `json:"records,omitempty"`
+}
+
+// Explain runs the rule's query once and explains the evaluation of its
+// conditions. Nothing is sent and the state of the rule, e.g. its
+// throttle and pending keys, is left untouched.
+func (q *QueryHandler) Explain(ctx context.Context) (*Explanation, error) {
+	respData, err := q.query(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	met, traces := config.ExplainConditions(q.withPrevious(respData), q.conditions)
+	e := &Explanation{
+		Rule:       q.name,
+		Met:        met,
+		Conditions: traces,
+	}
+	if !met {
+		return e, nil
+	}
+
+	e.Records, _, err = q.records(respData)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
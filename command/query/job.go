@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
 	multierror "github.com/hashicorp/go-multierror"
@@ -14,7 +15,10 @@ import (
 )
 
 type QueryHandlerConfig struct {
-	Name         string
+	Name string
+
+	// AlertMethods are the methods alerts of this rule are written to. If
+	// empty, the alert handler routes them by their labels
 	AlertMethods []alert.Method
 	Client       *elasticsearch.Client
 	ESUrl        string
@@ -24,6 +28,49 @@ type QueryHandlerConfig struct {
 	BodyField    string
 	Filters      []string
 	Conditions   []config.Condition
+	Labels       map[string]string
+	Severity     string
+
+	// Annotations are rendered for every alert of this rule
+	Annotations map[string]*alert.Template
+
+	// Realert suppresses repeat alerts of this rule within the given
+	// interval. RealertKey, if set, is a path in the search response
+	// whose values are throttled independently
+	Realert    time.Duration
+	RealertKey string
+
+	// DedupWindow is the period during which identical alerts of this
+	// rule are sent to their outputs only once. If DedupHits is true the
+	// '_id's of the matched hits are part of an alert's identity
+	DedupWindow time.Duration
+	DedupHits   bool
+
+	// For is how long a key must keep matching before it fires. If
+	// SendResolved is true an alert is also sent when firing keys stop
+	// matching
+	For          time.Duration
+	SendResolved bool
+
+	// Escalation lists who else to notify the longer a firing alert goes
+	// unacknowledged
+	Escalation []*alert.EscalationStep
+
+	// Template, if set, renders the message of the alerts of this rule
+	Template *alert.Template
+
+	// Attachment, if set, renders the hits found at BodyField into a file
+	// attached to the alerts of this rule instead of into their text
+	Attachment *AttachmentConfig
+
+	// Debug logs how the conditions of this rule were evaluated on every
+	// run
+	Debug bool
+
+	// KibanaURL and KibanaDataViewID, if set, are used to link alerts to
+	// the documents they are about in Kibana Discover
+	KibanaURL        string
+	KibanaDataViewID string
 }
 
 type QueryHandler struct {
@@ -40,6 +87,25 @@ type QueryHandler struct {
 	bodyField    string
 	filters      []string
 	conditions   []config.Condition
+	labels       map[string]string
+	severity     string
+	annotations  map[string]*alert.Template
+	throttle     *alert.Throttle
+	realertKey   string
+	dedupWindow  time.Duration
+	dedupHits    bool
+	states       *stateTracker
+	sendResolved bool
+	escalation   []*alert.EscalationStep
+	template     *alert.Template
+	attachment   *AttachmentConfig
+	debug        bool
+	discover     *discoverLinker
+	lastRun      time.Time
+
+	// previous is the response of the last run, which conditions may
+	// compare the current one to
+	previous map[string]interface{}
 }
 
 // TODO
@@ -67,6 +133,16 @@ func NewQueryHandler(config *QueryHandlerConfig) (*QueryHandler, error) {
 		return nil, fmt.Errorf("should init the client first")
 	}
 
+	var discover *discoverLinker
+	if config.KibanaURL != "" && config.KibanaDataViewID != "" {
+		discover = newDiscoverLinker(config.KibanaURL, config.KibanaDataViewID, config.QueryData)
+	}
+
+	var throttle *alert.Throttle
+	if config.Realert > 0 {
+		throttle = alert.NewThrottle(config.Realert)
+	}
+
 	return &QueryHandler{
 		StopCh: make(chan struct{}),
 
@@ -81,6 +157,20 @@ func NewQueryHandler(config *QueryHandlerConfig) (*QueryHandler, error) {
 		bodyField:    config.BodyField,
 		filters:      config.Filters,
 		conditions:   config.Conditions,
+		labels:       config.Labels,
+		severity:     config.Severity,
+		annotations:  config.Annotations,
+		throttle:     throttle,
+		realertKey:   config.RealertKey,
+		dedupWindow:  config.DedupWindow,
+		dedupHits:    config.DedupHits,
+		states:       newStateTracker(config.For),
+		sendResolved: config.SendResolved,
+		escalation:   config.Escalation,
+		template:     config.Template,
+		attachment:   config.Attachment,
+		debug:        config.Debug,
+		discover:     discover,
 	}, nil
 }
 
@@ -99,12 +189,12 @@ func validateConfig(config *QueryHandlerConfig) error {
 		allErrors = multierror.Append(allErrors, errors.New("no Elasticsearch Index provided"))
 	}
 
-	if len(config.AlertMethods) < 1 {
-		allErrors = multierror.Append(allErrors, errors.New("at least one alert method must be specified"))
-	}
-
 	if config.QueryData == nil || len(config.QueryData) < 1 {
 		allErrors = multierror.Append(allErrors, errors.New("no query body provided"))
 	}
+
+	if config.Attachment != nil && config.BodyField == "" {
+		allErrors = multierror.Append(allErrors, errors.New("attachments require a body field"))
+	}
 	return allErrors.ErrorOrNil()
 }
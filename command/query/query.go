@@ -0,0 +1,446 @@
+package query
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lbzss/elasticsearch-alert/command/alert"
+	"github.com/lbzss/elasticsearch-alert/utils"
+)
+
+// Run executes the query on every tick of the rule's schedule and sends
+// an alert to outputCh whenever the response yields records.
+func (q *QueryHandler) Run(ctx context.Context, outputCh chan<- *alert.Alert, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	now := time.Now()
+	timer := time.NewTimer(q.schedule.Next(now).Sub(now))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.StopCh:
+			return
+		case <-timer.C:
+			if err := q.run(ctx, time.Now(), outputCh); err != nil {
+				fmt.Println("error running query", "rule", q.name, "error", err)
+			}
+			now = time.Now()
+			timer.Reset(q.schedule.Next(now).Sub(now))
+		}
+	}
+}
+
+func (q *QueryHandler) run(ctx context.Context, now time.Time, outputCh chan<- *alert.Alert) error {
+	// The run covers the time since the previous one or, for the first
+	// run, one interval of the schedule
+	from := q.lastRun
+	if from.IsZero() {
+		from = now.Add(-q.schedule.Next(now).Sub(now))
+	}
+	q.lastRun = now
+
+	respData, err := q.query(ctx)
+	if err != nil {
+		return err
+	}
+
+	records, hits, err := q.process(respData)
+	q.previous = respData
+	if err != nil {
+		return fmt.Errorf("error processing response: %v", err)
+	}
+
+	records, resolved := q.states.update(records, now)
+	if q.throttle != nil {
+		q.resetThrottle(records, resolved)
+	}
+	if len(resolved) > 0 && q.sendResolved {
+		a := &alert.Alert{
+			ID:          alert.Fingerprint(q.name, resolved, nil),
+			RuleName:    q.name,
+			State:       alert.StateResolved,
+			Methods:     q.alertMethods,
+			Records:     resolved,
+			Labels:      q.labels,
+			Severity:    q.severity,
+			StillFiring: len(records) > 0,
+		}
+		if err := q.send(ctx, a, outputCh); err != nil {
+			return err
+		}
+	}
+
+	if len(records) < 1 {
+		return nil
+	}
+
+	var hitIDs []string
+	if q.dedupHits {
+		hitIDs = hitIDsOf(hits)
+	}
+
+	a := &alert.Alert{
+		RuleName:    q.name,
+		State:       alert.StateFiring,
+		Methods:     q.alertMethods,
+		Records:     records,
+		Hits:        hits,
+		Labels:      q.labels,
+		Severity:    q.severity,
+		EndsAt:      q.schedule.Next(q.schedule.Next(now)),
+		Escalation:  q.escalation,
+		DedupWindow: q.dedupWindow,
+	}
+
+	// Throttled alerts are still sent so that the alert handler knows the
+	// rule keeps firing, e.g. for inhibitions and escalations, but they
+	// are written to no output
+	if q.throttle != nil && !q.admit(a, respData, now) {
+		a.Throttled = true
+	}
+	a.ID = alert.Fingerprint(q.name, a.Records, hitIDs)
+
+	// The alert is still sent if its hits can't be attached, with the
+	// hits in the text of its body field record
+	if q.attachment != nil && len(hits) > 0 && !a.Throttled {
+		attachment, err := q.attachment.render(hits)
+		if err != nil {
+			fmt.Println("error rendering attachment", "rule", q.name, "error", err)
+		} else {
+			attach(a, attachment, len(hits))
+		}
+	}
+
+	if q.discover != nil {
+		q.discover.annotate(a, from, now)
+	}
+	return q.send(ctx, a, outputCh)
+}
+
+// attach adds the attachment to a and replaces the hits in the text of
+// its body field record by a reference to it.
+func attach(a *alert.Alert, attachment *alert.Attachment, hits int) {
+	a.Attachments = append(a.Attachments, attachment)
+	for i, record := range a.Records {
+		if !record.BodyField {
+			continue
+		}
+		cp := *record
+		cp.Text = fmt.Sprintf("%d hits attached as %s", hits, attachment.Filename)
+		a.Records[i] = &cp
+	}
+}
+
+func (q *QueryHandler) send(ctx context.Context, a *alert.Alert, outputCh chan<- *alert.Alert) error {
+	// An alert without a message is better than no alert at all
+	if len(q.annotations) > 0 {
+		a.Annotations = make(map[string]string, len(q.annotations))
+		for name, tmpl := range q.annotations {
+			v, err := tmpl.Execute(a)
+			if err != nil {
+				fmt.Println("error rendering annotation", "rule", q.name, "annotation", name, "error", err)
+			}
+			a.Annotations[name] = v
+		}
+	}
+
+	if q.template != nil {
+		msg, err := q.template.Execute(a)
+		if err != nil {
+			fmt.Println("error rendering message", "rule", q.name, "error", err)
+		}
+		a.Message = msg
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case outputCh <- a:
+	}
+	return nil
+}
+
+// maxCompositePages bounds the number of pages of a composite aggregation
+// fetched in a single run
+const maxCompositePages = 100
+
+func (q *QueryHandler) query(ctx context.Context) (map[string]interface{}, error) {
+	respData, err := q.search(ctx, q.queryData)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, composite := range compositeAggs(q.queryData) {
+		if err := q.paginate(ctx, respData, name, composite); err != nil {
+			return nil, err
+		}
+	}
+	return respData, nil
+}
+
+// paginate follows the 'after_key' of the composite aggregation name and
+// appends the buckets of all further pages to those in respData.
+func (q *QueryHandler) paginate(ctx context.Context, respData map[string]interface{}, name string, composite map[string]interface{}) error {
+	aggs, _ := respData["aggregations"].(map[string]interface{})
+	agg, ok := aggs[name].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	buckets, _ := agg["buckets"].([]interface{})
+	afterKey := agg["after_key"]
+	for page := 1; afterKey != nil; page++ {
+		if page >= maxCompositePages {
+			fmt.Println("too many pages of composite aggregation, ignoring the rest", "rule", q.name, "aggregation", name)
+			break
+		}
+
+		body := withCompositeAfter(q.queryData, name, composite, afterKey)
+		next, err := q.search(ctx, body)
+		if err != nil {
+			return err
+		}
+
+		nextAggs, _ := next["aggregations"].(map[string]interface{})
+		nextAgg, _ := nextAggs[name].(map[string]interface{})
+		more, _ := nextAgg["buckets"].([]interface{})
+		if len(more) < 1 {
+			break
+		}
+		buckets = append(buckets, more...)
+		afterKey = nextAgg["after_key"]
+	}
+
+	agg["buckets"] = buckets
+	delete(agg, "after_key")
+	return nil
+}
+
+// compositeAggs returns the top level composite aggregations of a query
+// body by name.
+func compositeAggs(body map[string]interface{}) map[string]map[string]interface{} {
+	composites := make(map[string]map[string]interface{})
+	for _, key := range []string{"aggs", "aggregations"} {
+		aggs, ok := body[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for name, raw := range aggs {
+			agg, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if composite, ok := agg["composite"].(map[string]interface{}); ok {
+				composites[name] = composite
+			}
+		}
+	}
+	return composites
+}
+
+// withCompositeAfter returns a copy of body requesting the page of the
+// composite aggregation name that follows afterKey. Only the maps on the
+// way to the aggregation are copied.
+func withCompositeAfter(body map[string]interface{}, name string, composite map[string]interface{}, afterKey interface{}) map[string]interface{} {
+	cp := make(map[string]interface{}, len(body))
+	for k, v := range body {
+		cp[k] = v
+	}
+
+	for _, key := range []string{"aggs", "aggregations"} {
+		aggs, ok := body[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		agg, ok := aggs[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		c := make(map[string]interface{}, len(composite)+1)
+		for k, v := range composite {
+			c[k] = v
+		}
+		c["after"] = afterKey
+
+		a := make(map[string]interface{}, len(agg))
+		for k, v := range agg {
+			a[k] = v
+		}
+		a["composite"] = c
+
+		as := make(map[string]interface{}, len(aggs))
+		for k, v := range aggs {
+			as[k] = v
+		}
+		as[name] = a
+		cp[key] = as
+	}
+	return cp
+}
+
+func (q *QueryHandler) search(ctx context.Context, body map[string]interface{}) (map[string]interface{}, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(body); err != nil {
+		return nil, fmt.Errorf("error JSON-encoding query body: %v", err)
+	}
+
+	res, err := q.client.Search(
+		q.client.Search.WithContext(ctx),
+		q.client.Search.WithIndex(q.queryIndex),
+		q.client.Search.WithBody(&buf),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying Elasticsearch: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error response from Elasticsearch: %s", res.String())
+	}
+
+	return decodeResponse(res.Body)
+}
+
+// admit applies the rule's realert throttle to a. It returns false if
+// every throttle key of the response is still within its window.
+// Otherwise the keys that got through open a new window and the alerts
+// suppressed for them are counted on a. If the keys are bucket keys, the
+// fields of the buckets still within their window are removed from a,
+// so that only the keys that got through are notified again.
+func (q *QueryHandler) admit(a *alert.Alert, respData map[string]interface{}, now time.Time) bool {
+	keys, buckets := q.throttleKeys(respData)
+	admitted := make([]string, 0)
+	suppressed := make(map[string]struct{})
+	for _, key := range keys {
+		if q.throttle.Suppress(key, now) {
+			suppressed[key] = struct{}{}
+			continue
+		}
+		admitted = append(admitted, key)
+	}
+	if len(admitted) < 1 {
+		return false
+	}
+
+	for _, key := range admitted {
+		a.Suppressed += q.throttle.Suppressed(key)
+		q.throttle.Sent(key, now)
+	}
+	if buckets && len(suppressed) > 0 {
+		a.Records = withoutKeys(a.Records, suppressed)
+	}
+	return true
+}
+
+// withoutKeys returns the records without the fields of the given keys.
+// Records left without fields are dropped.
+func withoutKeys(records []*alert.Record, keys map[string]struct{}) []*alert.Record {
+	kept := make([]*alert.Record, 0, len(records))
+	for _, record := range records {
+		if len(record.Fields) < 1 {
+			kept = append(kept, record)
+			continue
+		}
+
+		fields := make([]*alert.Field, 0, len(record.Fields))
+		for _, field := range record.Fields {
+			if _, ok := keys[field.Key]; !ok {
+				fields = append(fields, field)
+			}
+		}
+		if len(fields) > 0 {
+			r := *record
+			r.Fields = fields
+			kept = append(kept, &r)
+		}
+	}
+	return kept
+}
+
+// resetThrottle lets the next alert of the keys that resolved through
+// the rule's realert throttle, or of all keys if the rule stopped firing
+// altogether.
+func (q *QueryHandler) resetThrottle(firing, resolved []*alert.Record) {
+	if len(firing) < 1 {
+		q.throttle.ResetAll()
+		return
+	}
+	for _, record := range resolved {
+		for _, field := range record.Fields {
+			q.throttle.Reset(field.Key)
+		}
+	}
+}
+
+// throttleKeys returns the values found at the rule's realert key in the
+// response, and whether they are all bucket keys.
+func (q *QueryHandler) throttleKeys(respData map[string]interface{}) ([]string, bool) {
+	if q.realertKey == "" {
+		return []string{""}, false
+	}
+
+	seen := make(map[string]struct{})
+	keys := make([]string, 0)
+	buckets := true
+	for _, m := range utils.Find(respData, q.realertKey) {
+		if m.Value == nil {
+			continue
+		}
+
+		// Buckets are throttled by their key
+		key := m.Key()
+		if key == "" {
+			buckets = false
+		}
+		switch v := m.Value.(type) {
+		case map[string]interface{}:
+			if key != "" {
+				break
+			}
+			data, err := json.Marshal(v)
+			if err != nil {
+				continue
+			}
+			key = string(data)
+		case string:
+			key = v
+		case json.Number:
+			key = v.String()
+		default:
+			data, err := json.Marshal(v)
+			if err != nil {
+				continue
+			}
+			key = string(data)
+		}
+
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		keys = append(keys, key)
+	}
+
+	if len(keys) < 1 {
+		return []string{""}, false
+	}
+	return keys, buckets
+}
+
+func hitIDsOf(hits []map[string]interface{}) []string {
+	ids := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		if id, ok := hit["_id"].(string); ok && id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,119 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lbzss/elasticsearch-alert/command/alert"
+)
+
+func TestWithCompositeAfter(t *testing.T) {
+	body := map[string]interface{}{
+		"size": 0,
+		"aggs": map[string]interface{}{
+			"by_service": map[string]interface{}{
+				"composite": map[string]interface{}{
+					"sources": []interface{}{"service"},
+				},
+			},
+			"total": map[string]interface{}{"value_count": map[string]interface{}{"field": "_id"}},
+		},
+	}
+
+	composites := compositeAggs(body)
+	composite, ok := composites["by_service"]
+	if !ok || len(composites) != 1 {
+		t.Fatalf("expected one composite aggregation, got %v", composites)
+	}
+
+	after := map[string]interface{}{"service": "checkout"}
+	next := withCompositeAfter(body, "by_service", composite, after)
+	got := next["aggs"].(map[string]interface{})["by_service"].(map[string]interface{})["composite"].(map[string]interface{})
+	if !reflect.DeepEqual(got["after"], after) {
+		t.Fatalf("expected 'after' to be set, got %v", got)
+	}
+	if _, ok := composite["after"]; ok {
+		t.Fatal("the rule's query body must not be modified")
+	}
+	if next["size"] != 0 || next["aggs"].(map[string]interface{})["total"] == nil {
+		t.Fatalf("expected the rest of the body to be kept, got %v", next)
+	}
+}
+
+func bucketRecords(keys ...string) []*alert.Record {
+	if len(keys) < 1 {
+		return nil
+	}
+	fields := make([]*alert.Field, 0, len(keys))
+	for _, key := range keys {
+		fields = append(fields, &alert.Field{Key: key, Count: 1})
+	}
+	return []*alert.Record{{Filter: "aggregations.hosts.buckets", Fields: fields}}
+}
+
+func fieldKeys(records []*alert.Record) map[string]bool {
+	keys := make(map[string]bool)
+	for _, record := range records {
+		for _, field := range record.Fields {
+			keys[field.Key] = true
+		}
+	}
+	return keys
+}
+
+func TestAdmit(t *testing.T) {
+	q := &QueryHandler{
+		name:       "rule",
+		throttle:   alert.NewThrottle(time.Hour),
+		realertKey: "aggregations.hosts.buckets",
+	}
+	response := func(keys ...string) map[string]interface{} {
+		buckets := make([]interface{}, 0, len(keys))
+		for _, key := range keys {
+			buckets = append(buckets, map[string]interface{}{"key": key, "doc_count": 1})
+		}
+		return map[string]interface{}{
+			"aggregations": map[string]interface{}{
+				"hosts": map[string]interface{}{"buckets": buckets},
+			},
+		}
+	}
+
+	now := time.Now()
+	a := &alert.Alert{Records: bucketRecords("web-1")}
+	if !q.admit(a, response("web-1"), now) {
+		t.Fatal("expected the first alert to be admitted")
+	}
+
+	a = &alert.Alert{Records: bucketRecords("web-1")}
+	if q.admit(a, response("web-1"), now.Add(time.Minute)) {
+		t.Fatal("expected keys within their window to be throttled")
+	}
+
+	a = &alert.Alert{Records: bucketRecords("web-1", "web-2")}
+	if !q.admit(a, response("web-1", "web-2"), now.Add(2*time.Minute)) {
+		t.Fatal("expected new keys to be admitted")
+	}
+	if keys := fieldKeys(a.Records); len(keys) != 1 || !keys["web-2"] {
+		t.Fatalf("expected only the admitted key to be notified, got %v", keys)
+	}
+	if a.Suppressed != 0 {
+		t.Fatalf("expected no suppressed alerts to be counted for the new key, got %d", a.Suppressed)
+	}
+
+	q.resetThrottle(bucketRecords("web-2"), bucketRecords("web-1"))
+	a = &alert.Alert{Records: bucketRecords("web-1", "web-2")}
+	if !q.admit(a, response("web-1", "web-2"), now.Add(3*time.Minute)) {
+		t.Fatal("expected keys that resolved to be admitted again")
+	}
+	if keys := fieldKeys(a.Records); len(keys) != 1 || !keys["web-1"] {
+		t.Fatalf("expected only the key that resolved to be notified, got %v", keys)
+	}
+
+	q.resetThrottle(nil, bucketRecords("web-1", "web-2"))
+	a = &alert.Alert{Records: bucketRecords("web-1", "web-2")}
+	if !q.admit(a, response("web-1", "web-2"), now.Add(4*time.Minute)) || len(fieldKeys(a.Records)) != 2 {
+		t.Fatal("expected all keys to be admitted again once the rule resolved")
+	}
+}
@@ -0,0 +1,31 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// decodeResponse decodes the body of an Elasticsearch search response.
+// It is how every response reaches process and the rule's conditions:
+// numbers are decoded as json.Numbers, so longs beyond 2^53 and decimals
+// are compared exactly, and null values, e.g. those of metric
+// aggregations over no documents, are kept as nil so that conditions
+// consider them missing.
+func decodeResponse(r io.Reader) (map[string]interface{}, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	var respData map[string]interface{}
+	if err := dec.Decode(&respData); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding Elasticsearch response: %v", err)
+	}
+	if respData == nil {
+		return nil, errors.New("error JSON-decoding Elasticsearch response: response is null")
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("error JSON-decoding Elasticsearch response: unexpected data after the response")
+	}
+	return respData, nil
+}
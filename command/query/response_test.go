@@ -0,0 +1,42 @@
+package query
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDecodeResponse(t *testing.T) {
+	resp, err := decodeResponse(strings.NewReader(`{
+		"hits": {"total": {"value": 9007199254740993}},
+		"aggregations": {"latency": {"value": null}, "ratio": {"value": 0.1}}
+	}` + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total := resp["hits"].(map[string]interface{})["total"].(map[string]interface{})["value"]
+	if total != json.Number("9007199254740993") {
+		t.Errorf("expected large longs to be preserved, got %v", total)
+	}
+	aggs := resp["aggregations"].(map[string]interface{})
+	if v, ok := aggs["latency"].(map[string]interface{})["value"]; !ok || v != nil {
+		t.Errorf("expected null aggregation values to be kept as nil, got %v", v)
+	}
+	if v := aggs["ratio"].(map[string]interface{})["value"]; v != json.Number("0.1") {
+		t.Errorf("expected decimals to be preserved, got %v", v)
+	}
+
+	invalid := []string{
+		``,
+		`null`,
+		`[]`,
+		`{"took": 5`,
+		`{"took": 5} {"took": 6}`,
+	}
+	for _, data := range invalid {
+		if _, err := decodeResponse(strings.NewReader(data)); err == nil {
+			t.Errorf("expected response %q to be invalid", data)
+		}
+	}
+}
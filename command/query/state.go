@@ -0,0 +1,111 @@
+package query
+
+import (
+	"time"
+
+	"github.com/lbzss/elasticsearch-alert/command/alert"
+)
+
+// stateTracker keeps the alert state of every key a rule reported on
+// across runs. A key is a bucket key of one of the rule's filters or,
+// for records without fields, the filter itself.
+type stateTracker struct {
+	pendingFor time.Duration
+	states     map[string]*keyState
+}
+
+type keyState struct {
+	state  alert.State
+	since  time.Time
+	filter string
+	key    string
+}
+
+func newStateTracker(pendingFor time.Duration) *stateTracker {
+	return &stateTracker{
+		pendingFor: pendingFor,
+		states:     make(map[string]*keyState),
+	}
+}
+
+func stateKey(filter, key string) string {
+	if key == "" {
+		return filter
+	}
+	return filter + "\x1f" + key
+}
+
+// update advances the state of every key given the records of the
+// current run. Keys that are new become pending and fire once they have
+// been reported for pendingFor. Keys that are no longer reported are
+// forgotten, and resolved if they were firing. It returns the records
+// restricted to firing keys, and records of the keys that resolved.
+func (s *stateTracker) update(records []*alert.Record, now time.Time) ([]*alert.Record, []*alert.Record) {
+	seen := make(map[string]struct{})
+	advance := func(filter, key string) bool {
+		id := stateKey(filter, key)
+		seen[id] = struct{}{}
+
+		ks, ok := s.states[id]
+		if !ok {
+			ks = &keyState{
+				state:  alert.StatePending,
+				since:  now,
+				filter: filter,
+				key:    key,
+			}
+			s.states[id] = ks
+		}
+
+		if ks.state == alert.StatePending && now.Sub(ks.since) >= s.pendingFor {
+			ks.state = alert.StateFiring
+			ks.since = now
+		}
+		return ks.state == alert.StateFiring
+	}
+
+	firing := make([]*alert.Record, 0, len(records))
+	for _, record := range records {
+		if len(record.Fields) < 1 {
+			if advance(record.Filter, "") {
+				firing = append(firing, record)
+			}
+			continue
+		}
+
+		fields := make([]*alert.Field, 0, len(record.Fields))
+		for _, field := range record.Fields {
+			if advance(record.Filter, field.Key) {
+				fields = append(fields, field)
+			}
+		}
+		if len(fields) > 0 {
+			r := *record
+			r.Fields = fields
+			firing = append(firing, &r)
+		}
+	}
+
+	resolved := make([]*alert.Record, 0)
+	byFilter := make(map[string]*alert.Record)
+	for id, ks := range s.states {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		delete(s.states, id)
+		if ks.state != alert.StateFiring {
+			continue
+		}
+
+		record, ok := byFilter[ks.filter]
+		if !ok {
+			record = &alert.Record{Filter: ks.filter}
+			byFilter[ks.filter] = record
+			resolved = append(resolved, record)
+		}
+		if ks.key != "" {
+			record.Fields = append(record.Fields, &alert.Field{Key: ks.key})
+		}
+	}
+	return firing, resolved
+}
@@ -0,0 +1,47 @@
+package query
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateTracker(t *testing.T) {
+	now := time.Now()
+	s := newStateTracker(2 * time.Minute)
+
+	firing, resolved := s.update(bucketRecords("foo"), now)
+	if len(firing) != 0 || len(resolved) != 0 {
+		t.Fatal("new keys should be pending")
+	}
+
+	firing, _ = s.update(bucketRecords("foo", "bar"), now.Add(time.Minute))
+	if len(firing) != 0 {
+		t.Fatal("keys should stay pending for the 'for' duration")
+	}
+
+	firing, _ = s.update(bucketRecords("foo", "bar"), now.Add(2*time.Minute))
+	if keys := fieldKeys(firing); !keys["foo"] || keys["bar"] {
+		t.Fatalf("expected only foo to fire, got %v", keys)
+	}
+
+	firing, resolved = s.update(bucketRecords("bar"), now.Add(3*time.Minute))
+	if keys := fieldKeys(firing); !keys["bar"] || len(keys) != 1 {
+		t.Fatalf("expected bar to fire, got %v", keys)
+	}
+	if keys := fieldKeys(resolved); !keys["foo"] || len(keys) != 1 {
+		t.Fatalf("expected foo to resolve, got %v", keys)
+	}
+
+	firing, resolved = s.update(nil, now.Add(4*time.Minute))
+	if len(firing) != 0 {
+		t.Fatal("nothing should fire without records")
+	}
+	if keys := fieldKeys(resolved); !keys["bar"] || len(keys) != 1 {
+		t.Fatalf("expected bar to resolve, got %v", keys)
+	}
+
+	firing, _ = s.update(bucketRecords("foo"), now.Add(5*time.Minute))
+	if len(firing) != 0 {
+		t.Fatal("resolved keys should be pending again when they reappear")
+	}
+}
@@ -2,6 +2,7 @@ package query
 
 import (
 	"encoding/json"
+	"fmt"
 	"strings"
 
 	"github.com/lbzss/elasticsearch-alert/command/alert"
@@ -13,18 +14,23 @@ import (
 const hitsDelimiter = "\n----------------------------------------\n"
 
 func (q *QueryHandler) process(respData map[string]interface{}) ([]*alert.Record, []map[string]interface{}, error) {
-	if len(q.conditions) != 0 && !config.ConditionsMet(respData, q.conditions) {
+	if len(q.conditions) != 0 && !q.conditionsMet(respData) {
 		return nil, nil, nil
 	}
+	return q.records(respData)
+}
 
+// records gathers the records and hits of a response regardless of the
+// rule's conditions.
+func (q *QueryHandler) records(respData map[string]interface{}) ([]*alert.Record, []map[string]interface{}, error) {
 	records := make([]*alert.Record, 0)
 	for _, filter := range q.filters {
-		elems := utils.GetAll(respData, filter)
-		if elems == nil || len(elems) < 1 {
+		matches := utils.Find(respData, filter)
+		if len(matches) < 1 {
 			continue
 		}
 
-		fields, err := q.gatherFields(elems)
+		fields, err := q.gatherFields(matches)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -41,10 +47,10 @@ func (q *QueryHandler) process(respData map[string]interface{}) ([]*alert.Record
 		records = append(records, record)
 	}
 
-	body := utils.GetAll(respData, q.bodyField)
-	if body == nil {
+	if q.bodyField == "" {
 		return records, nil, nil
 	}
+	body := utils.Find(respData, q.bodyField)
 
 	stringfieldHits, hits, err := q.gatherHits(body)
 	if err != nil {
@@ -61,11 +67,44 @@ func (q *QueryHandler) process(respData map[string]interface{}) ([]*alert.Record
 	return records, hits, nil
 }
 
-func (q *QueryHandler) gatherHits(body []interface{}) ([]string, []map[string]interface{}, error) {
+// conditionsMet evaluates the rule's conditions, logging how they were
+// evaluated if the rule is being debugged.
+func (q *QueryHandler) conditionsMet(respData map[string]interface{}) bool {
+	env := q.withPrevious(respData)
+	if !q.debug {
+		return config.ConditionsMet(env, q.conditions)
+	}
+
+	met, traces := config.ExplainConditions(env, q.conditions)
+	data, err := json.Marshal(traces)
+	if err != nil {
+		fmt.Println("error JSON-encoding condition traces", "rule", q.name, "error", err)
+		return met
+	}
+	fmt.Println("evaluated conditions", "rule", q.name, "met", met, "traces", string(data))
+	return met
+}
+
+// withPrevious returns a shallow copy of respData exposing the response
+// of the previous run to conditions. It is omitted before the first run.
+func (q *QueryHandler) withPrevious(respData map[string]interface{}) map[string]interface{} {
+	if q.previous == nil {
+		return respData
+	}
+
+	env := make(map[string]interface{}, len(respData)+1)
+	for k, v := range respData {
+		env[k] = v
+	}
+	env[config.PreviousKey] = q.previous
+	return env
+}
+
+func (q *QueryHandler) gatherHits(body []utils.Match) ([]string, []map[string]interface{}, error) {
 	stringfieldHits := make([]string, 0, len(body))
 	hits := make([]map[string]interface{}, 0, len(body))
-	for _, elem := range body {
-		hit, ok := elem.(map[string]interface{})
+	for _, m := range body {
+		hit, ok := m.Value.(map[string]interface{})
 		if !ok {
 			continue
 		}
@@ -80,23 +119,102 @@ func (q *QueryHandler) gatherHits(body []interface{}) ([]string, []map[string]in
 	return stringfieldHits, hits, nil
 }
 
-func (q *QueryHandler) gatherFields(elems []interface{}) ([]*alert.Field, error) {
-	fields := make([]*alert.Field, 0, len(elems))
-	for _, elem := range elems {
-		obj, ok := elem.(map[string]interface{})
+func (q *QueryHandler) gatherFields(matches []utils.Match) ([]*alert.Field, error) {
+	fields := make([]*alert.Field, 0, len(matches))
+	for _, m := range matches {
+		obj, ok := m.Value.(map[string]interface{})
 		if !ok {
 			continue
 		}
 
+		// The key of a field is the chained key of its bucket, which may
+		// differ from the raw key, e.g. of 'composite' buckets
+		bucket := make(map[string]interface{}, len(obj))
+		for k, v := range obj {
+			bucket[k] = v
+		}
+		bucket["key"] = m.Key()
+
 		field := new(alert.Field)
-		if err := mapstructure.Decode(obj, field); err != nil {
+		if err := mapstructure.Decode(bucket, field); err != nil {
 			return nil, err
 		}
 
 		if field.Key == "" || field.Count < 1 {
 			continue
 		}
+		field.Metrics = metricsOf(obj)
 		fields = append(fields, field)
 	}
 	return fields, nil
 }
+
+// metricsOf returns the values of the metric sub-aggregations of a
+// bucket, or nil if it has none. Bucket sub-aggregations are skipped.
+func metricsOf(bucket map[string]interface{}) map[string]interface{} {
+	var metrics map[string]interface{}
+	for name, raw := range bucket {
+		agg, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		v, ok := metricValue(agg)
+		if !ok {
+			continue
+		}
+		if metrics == nil {
+			metrics = make(map[string]interface{})
+		}
+		metrics[name] = v
+	}
+	return metrics
+}
+
+func metricValue(agg map[string]interface{}) (interface{}, bool) {
+	if _, ok := agg["buckets"]; ok {
+		return nil, false
+	}
+
+	// Single-value metrics, e.g. 'avg', 'max' or 'cardinality'
+	if v, ok := agg["value"]; ok {
+		return v, true
+	}
+
+	// Percentiles, keyed or not
+	switch values := agg["values"].(type) {
+	case map[string]interface{}:
+		return values, true
+	case []interface{}:
+		m := make(map[string]interface{}, len(values))
+		for _, elem := range values {
+			if p, ok := elem.(map[string]interface{}); ok {
+				m[fmt.Sprint(p["key"])] = p["value"]
+			}
+		}
+		return m, true
+	}
+
+	if hits, ok := agg["hits"].(map[string]interface{}); ok {
+		list, _ := hits["hits"].([]interface{})
+		sources := make([]interface{}, 0, len(list))
+		for _, elem := range list {
+			if hit, ok := elem.(map[string]interface{}); ok {
+				sources = append(sources, hit["_source"])
+			}
+		}
+		return sources, true
+	}
+
+	// Multi-value metrics, e.g. 'stats' or 'extended_stats'
+	if _, ok := agg["count"]; ok {
+		m := make(map[string]interface{}, len(agg))
+		for k, v := range agg {
+			if _, nested := v.(map[string]interface{}); !nested {
+				m[k] = v
+			}
+		}
+		return m, true
+	}
+	return nil, false
+}
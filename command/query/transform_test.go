@@ -0,0 +1,120 @@
+package query
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/lbzss/elasticsearch-alert/config"
+)
+
+const metricsResponse = `{
+	"aggregations": {
+		"services": {
+			"buckets": [
+				{
+					"key": "checkout",
+					"doc_count": 120,
+					"avg_latency": {"value": 240.5},
+					"latency": {"values": {"50.0": 180, "99.0": 812}},
+					"status": {"count": 120, "min": 200, "max": 503, "avg": 211.3, "sum": 25356},
+					"slowest": {"hits": {"total": {"value": 120}, "hits": [{"_id": "a", "_source": {"path": "/pay"}}]}},
+					"hosts": {"buckets": []}
+				},
+				{"key": "search", "doc_count": 3}
+			]
+		}
+	}
+}`
+
+func parseResponse(t *testing.T, data string) map[string]interface{} {
+	t.Helper()
+	resp, err := decodeResponse(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestProcessMetrics(t *testing.T) {
+	q := &QueryHandler{filters: []string{"aggregations.services.buckets"}}
+	records, _, err := q.process(parseResponse(t, metricsResponse))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || len(records[0].Fields) != 2 {
+		t.Fatalf("expected one record with two fields, got %+v", records)
+	}
+
+	checkout := records[0].Fields[0]
+	cases := map[string]string{
+		"avg_latency":  "240.5",
+		"latency.99.0": "812",
+		"status.max":   "503",
+	}
+	for path, expected := range cases {
+		if v := checkout.Metric(path); v != json.Number(expected) {
+			t.Errorf("expected metric %s to be %s, got %v", path, expected, v)
+		}
+	}
+	if hits, ok := checkout.Metric("slowest").([]interface{}); !ok || len(hits) != 1 {
+		t.Errorf("expected the top hits' sources, got %v", checkout.Metric("slowest"))
+	}
+	if _, ok := checkout.Metrics["hosts"]; ok {
+		t.Error("bucket sub-aggregations should not be metrics")
+	}
+	if records[0].Fields[1].Metrics != nil {
+		t.Errorf("expected no metrics, got %v", records[0].Fields[1].Metrics)
+	}
+
+	condition, err := config.ParseCondition(map[string]interface{}{
+		"field":      "aggregations.services.buckets.latency.values.99.0",
+		"quantifier": "any",
+		"gt":         json.Number("1000"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.conditions = []config.Condition{condition}
+	if records, _, _ = q.process(parseResponse(t, metricsResponse)); records != nil {
+		t.Fatal("expected conditions on percentiles to be evaluated")
+	}
+}
+
+func TestProcessPrevious(t *testing.T) {
+	condition, err := config.ParseCondition(map[string]interface{}{
+		"field":                "aggregations.services.buckets.doc_count",
+		"quantifier":           "any",
+		"increased_by_percent": json.Number("50"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := &QueryHandler{
+		filters:    []string{"aggregations.services.buckets"},
+		conditions: []config.Condition{condition},
+	}
+
+	if q.conditionsMet(parseResponse(t, metricsResponse)) {
+		t.Fatal("expected no increase without a previous run")
+	}
+
+	q.previous = parseResponse(t, `{"aggregations": {"services": {"buckets": [
+		{"key": "search", "doc_count": 3},
+		{"key": "checkout", "doc_count": 100}
+	]}}}`)
+	if q.conditionsMet(parseResponse(t, metricsResponse)) {
+		t.Fatal("expected an increase of 20% not to meet the condition")
+	}
+
+	q.previous = parseResponse(t, `{"aggregations": {"services": {"buckets": [
+		{"key": "checkout", "doc_count": 60}
+	]}}}`)
+	resp := parseResponse(t, metricsResponse)
+	if !q.conditionsMet(resp) {
+		t.Fatal("expected an increase of 100% to meet the condition")
+	}
+	if _, ok := resp[config.PreviousKey]; ok {
+		t.Error("the response should not be modified")
+	}
+}
@@ -0,0 +1,321 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/lbzss/elasticsearch-alert/command/alert"
+	"github.com/lbzss/elasticsearch-alert/command/alert/file"
+	"github.com/lbzss/elasticsearch-alert/command/query"
+	"github.com/lbzss/elasticsearch-alert/config"
+	"github.com/mitchellh/mapstructure"
+)
+
+func runDaemon() int {
+	cfg, err := config.ParseConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error parsing configuration: %v\n", err)
+		return 1
+	}
+
+	client, err := cfg.NewESClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating Elasticsearch client: %v\n", err)
+		return 1
+	}
+
+	silencesFile, err := config.SilencesFile()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	silences, err := alert.OpenSilenceStore(silencesFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	acksFile, err := config.AcksFile()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	acks, err := alert.OpenAckStore(acksFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	templates, err := alert.LoadTemplateLibrary(cfg.TemplatesDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	queryHandlers := make([]*query.QueryHandler, 0, len(cfg.Rules))
+	for _, rule := range cfg.Rules {
+		methods, err := buildMethods(rule.Outputs, templates)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error in outputs of rule %s: %v\n", rule.Name, err)
+			return 1
+		}
+
+		escalation := make([]*alert.EscalationStep, 0, len(rule.Escalation))
+		for i, step := range rule.Escalation {
+			stepMethods, err := buildMethods(step.Outputs, templates)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error in outputs of escalation step %d of rule %s: %v\n", i+1, rule.Name, err)
+				return 1
+			}
+			escalation = append(escalation, &alert.EscalationStep{
+				After:   step.Duration,
+				Methods: stepMethods,
+			})
+		}
+
+		handlerConfig := &query.QueryHandlerConfig{
+			Name:         rule.Name,
+			AlertMethods: methods,
+			Client:       client,
+			ESUrl:        cfg.Elasticsearch.Server.ElasticsearchURL,
+			QueryData:    rule.ElasticsearchBody,
+			QueryIndex:   rule.ElasticsearchIndex,
+			Schedule:     rule.CronSchedule,
+			BodyField:    rule.BodyField,
+			Debug:        rule.Debug,
+			Filters:      rule.Filters,
+			Conditions:   rule.Conditions,
+			Labels:       rule.Labels,
+			Severity:     rule.Severity,
+			For:          rule.ForDuration,
+			SendResolved: rule.SendResolved,
+			Escalation:   escalation,
+		}
+		if cfg.Kibana != nil {
+			handlerConfig.KibanaURL = cfg.Kibana.URL
+			handlerConfig.KibanaDataViewID = cfg.Kibana.DataViewID
+			if rule.KibanaDataViewID != "" {
+				handlerConfig.KibanaDataViewID = rule.KibanaDataViewID
+			}
+		}
+		if rule.Realert != nil {
+			handlerConfig.Realert = rule.Realert.Duration
+			handlerConfig.RealertKey = rule.Realert.Key
+		}
+		if rule.Attachment != nil {
+			handlerConfig.Attachment = &query.AttachmentConfig{
+				Format:   rule.Attachment.Format,
+				Columns:  rule.Attachment.Columns,
+				Filename: rule.Attachment.Filename,
+			}
+		}
+		if rule.Dedup != nil {
+			handlerConfig.DedupWindow = rule.Dedup.Duration
+			handlerConfig.DedupHits = rule.Dedup.IncludeHits
+		}
+		if len(rule.Annotations) > 0 {
+			handlerConfig.Annotations = make(map[string]*alert.Template, len(rule.Annotations))
+			for name, text := range rule.Annotations {
+				tmpl, err := templates.NewTemplate(text, "", alert.TemplateFormatText)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error in annotation %s of rule %s: %v\n", name, rule.Name, err)
+					return 1
+				}
+				handlerConfig.Annotations[name] = tmpl
+			}
+		}
+		if rule.Template != nil {
+			handlerConfig.Template, err = templates.NewTemplate(rule.Template.Text, rule.Template.Name, rule.Template.Format)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error in template of rule %s: %v\n", rule.Name, err)
+				return 1
+			}
+		}
+
+		qh, err := query.NewQueryHandler(handlerConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error creating query handler for rule %s: %v\n", rule.Name, err)
+			return 1
+		}
+		queryHandlers = append(queryHandlers, qh)
+	}
+
+	receivers := make(map[string][]alert.Method, len(cfg.Receivers))
+	for _, receiver := range cfg.Receivers {
+		methods, err := buildMethods(receiver.Outputs, templates)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error in outputs of receiver %s: %v\n", receiver.Name, err)
+			return 1
+		}
+		receivers[receiver.Name] = methods
+	}
+
+	var route *alert.Route
+	if cfg.Route != nil {
+		route, err = buildRoute(cfg.Route)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error in route: %v\n", err)
+			return 1
+		}
+	}
+
+	var inhibitor *alert.Inhibitor
+	if len(cfg.InhibitRules) > 0 {
+		rules := make([]*alert.InhibitRule, 0, len(cfg.InhibitRules))
+		for i, c := range cfg.InhibitRules {
+			rule, err := buildInhibitRule(c)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error in inhibit rule %d: %v\n", i+1, err)
+				return 1
+			}
+			rules = append(rules, rule)
+		}
+		inhibitor = alert.NewInhibitor(rules)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	outputCh := make(chan *alert.Alert, 1)
+	handler := alert.NewHandler(&alert.HandlerConfig{
+		Silences:  silences,
+		Route:     route,
+		Receivers: receivers,
+		Inhibitor: inhibitor,
+		Acks:      acks,
+	})
+	go handler.Run(ctx, outputCh)
+
+	if cfg.HTTP != nil {
+		server := newHTTPServer(cfg.HTTP, acks, handler.Escalating)
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fmt.Fprintf(os.Stderr, "error running HTTP server: %v\n", err)
+			}
+		}()
+		defer server.Close()
+	}
+
+	wg := new(sync.WaitGroup)
+	for _, qh := range queryHandlers {
+		wg.Add(1)
+		go qh.Run(ctx, outputCh, wg)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+
+	cancel()
+	wg.Wait()
+	<-handler.DoneCh
+	return 0
+}
+
+// buildMethods creates the alert methods of a rule's outputs.
+func buildMethods(outputs []config.OutputConfig, templates *alert.TemplateLibrary) ([]alert.Method, error) {
+	methods := make([]alert.Method, 0, len(outputs))
+	for i, output := range outputs {
+		method, err := buildMethod(output, templates)
+		if err != nil {
+			return nil, fmt.Errorf("error in output %d: %v", i+1, err)
+		}
+		methods = append(methods, method)
+	}
+	return methods, nil
+}
+
+func buildMethod(output config.OutputConfig, templates *alert.TemplateLibrary) (alert.Method, error) {
+	var method alert.Method
+	switch output.Type {
+	case "file":
+		c := new(file.AlertMethodConfig)
+		if err := mapstructure.Decode(output.Config, c); err != nil {
+			return nil, fmt.Errorf("error decoding file output configuration: %v", err)
+		}
+
+		m, err := file.NewAlertMethod(c)
+		if err != nil {
+			return nil, err
+		}
+		method = m
+	default:
+		return nil, fmt.Errorf("unsupported output type %q", output.Type)
+	}
+
+	if output.Template != nil {
+		tmpl, err := templates.NewTemplate(output.Template.Text, output.Template.Name, output.Template.Format)
+		if err != nil {
+			return nil, fmt.Errorf("error in template: %v", err)
+		}
+		method = alert.Templated(method, tmpl)
+	}
+
+	if output.Realert != nil {
+		method = alert.Throttled(method, output.Realert.Duration)
+	}
+
+	// The digest must wrap everything else so the alert handler can find it
+	if output.Digest != nil {
+		method = alert.NewDigest(method, output.Digest.Cron)
+	}
+	return method, nil
+}
+
+// buildRoute creates the routing tree described by config.
+func buildRoute(config *config.RouteConfig) (*alert.Route, error) {
+	matchers, err := parseMatchers(config.Matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	route := &alert.Route{
+		Receiver: config.Receiver,
+		Matchers: matchers,
+		Continue: config.Continue,
+	}
+
+	for _, child := range config.Routes {
+		r, err := buildRoute(child)
+		if err != nil {
+			return nil, err
+		}
+		route.Routes = append(route.Routes, r)
+	}
+	return route, nil
+}
+
+func buildInhibitRule(config config.InhibitRuleConfig) (*alert.InhibitRule, error) {
+	source, err := parseMatchers(config.SourceMatchers)
+	if err != nil {
+		return nil, fmt.Errorf("error in source matchers: %v", err)
+	}
+
+	target, err := parseMatchers(config.TargetMatchers)
+	if err != nil {
+		return nil, fmt.Errorf("error in target matchers: %v", err)
+	}
+
+	return &alert.InhibitRule{
+		SourceMatchers: source,
+		TargetMatchers: target,
+		Equal:          config.Equal,
+	}, nil
+}
+
+func parseMatchers(ss []string) ([]*alert.Matcher, error) {
+	matchers := make([]*alert.Matcher, 0, len(ss))
+	for _, s := range ss {
+		m, err := alert.ParseMatcher(s)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
@@ -0,0 +1,220 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lbzss/elasticsearch-alert/command/alert"
+	"github.com/lbzss/elasticsearch-alert/config"
+)
+
+const silenceUsage = `Usage: elasticsearch-alert silence <subcommand> [options]
+
+Subcommands:
+    add       Add a silence
+    list      List active and pending silences
+    expire    Expire silences by ID
+
+Matchers have the form name=value, name!=value, name=~regex or
+name!~regex, where name is 'rule', 'key', 'filter', 'state', 'severity'
+or the name of a rule label. Silences with a 'key' matcher mute only the
+matching keys of an alert. Expired silences are removed after 5 days.
+`
+
+func runSilence(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, silenceUsage)
+		return 1
+	}
+
+	var err error
+	switch args[0] {
+	case "add":
+		err = silenceAdd(args[1:])
+	case "list":
+		err = silenceList(args[1:])
+	case "expire":
+		err = silenceExpire(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, silenceUsage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown subcommand %q\n\n%s", args[0], silenceUsage)
+		return 1
+	}
+
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return 1
+	}
+	return 0
+}
+
+// openSilenceStore opens the silences file given by the -file flag or,
+// if that is empty, the configured one.
+func openSilenceStore(path string) (*alert.SilenceStore, error) {
+	if path == "" {
+		f, err := config.SilencesFile()
+		if err != nil {
+			return nil, err
+		}
+		path = f
+	}
+	return alert.OpenSilenceStore(path)
+}
+
+func silenceAdd(args []string) error {
+	var (
+		matchers stringsFlag
+		path     string
+		start    string
+		end      string
+		duration time.Duration
+		author   string
+		comment  string
+	)
+	flags := flag.NewFlagSet("silence add", flag.ContinueOnError)
+	flags.Var(&matchers, "matcher", "matcher of the alerts to silence, may be repeated")
+	flags.StringVar(&path, "file", "", "path of the silences file")
+	flags.StringVar(&start, "start", "", "start of the silence in RFC3339 format (default now)")
+	flags.StringVar(&end, "end", "", "end of the silence in RFC3339 format")
+	flags.DurationVar(&duration, "duration", time.Hour, "duration of the silence, ignored if -end is given")
+	flags.StringVar(&author, "author", os.Getenv("USER"), "author of the silence")
+	flags.StringVar(&comment, "comment", "", "reason for the silence")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if len(matchers) < 1 {
+		return errors.New("at least one -matcher must be specified")
+	}
+	if author == "" {
+		return errors.New("no -author provided")
+	}
+	if comment == "" {
+		return errors.New("no -comment provided")
+	}
+
+	silence := &alert.Silence{
+		StartsAt:  time.Now(),
+		CreatedBy: author,
+		Comment:   comment,
+	}
+	for _, s := range matchers {
+		m, err := alert.ParseMatcher(s)
+		if err != nil {
+			return err
+		}
+		silence.Matchers = append(silence.Matchers, m)
+	}
+
+	if start != "" {
+		t, err := time.Parse(time.RFC3339, start)
+		if err != nil {
+			return fmt.Errorf("error parsing -start: %v", err)
+		}
+		silence.StartsAt = t
+	}
+	silence.EndsAt = silence.StartsAt.Add(duration)
+	if end != "" {
+		t, err := time.Parse(time.RFC3339, end)
+		if err != nil {
+			return fmt.Errorf("error parsing -end: %v", err)
+		}
+		silence.EndsAt = t
+	}
+
+	store, err := openSilenceStore(path)
+	if err != nil {
+		return err
+	}
+	if err := store.Add(silence); err != nil {
+		return fmt.Errorf("error adding silence: %v", err)
+	}
+	fmt.Println(silence.ID)
+	return nil
+}
+
+func silenceList(args []string) error {
+	var (
+		path string
+		all  bool
+	)
+	flags := flag.NewFlagSet("silence list", flag.ContinueOnError)
+	flags.StringVar(&path, "file", "", "path of the silences file")
+	flags.BoolVar(&all, "all", false, "include expired silences")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := openSilenceStore(path)
+	if err != nil {
+		return err
+	}
+	silences, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tMATCHERS\tSTARTS\tENDS\tSTATE\tAUTHOR\tCOMMENT")
+	for _, silence := range silences {
+		state := "active"
+		switch {
+		case silence.Expired(now):
+			if !all {
+				continue
+			}
+			state = "expired"
+		case !silence.Active(now):
+			state = "pending"
+		}
+
+		matchers := make([]string, 0, len(silence.Matchers))
+		for _, m := range silence.Matchers {
+			matchers = append(matchers, m.String())
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			silence.ID,
+			strings.Join(matchers, ","),
+			silence.StartsAt.Format(time.RFC3339),
+			silence.EndsAt.Format(time.RFC3339),
+			state,
+			silence.CreatedBy,
+			silence.Comment,
+		)
+	}
+	return w.Flush()
+}
+
+func silenceExpire(args []string) error {
+	var path string
+	flags := flag.NewFlagSet("silence expire", flag.ContinueOnError)
+	flags.StringVar(&path, "file", "", "path of the silences file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() < 1 {
+		return errors.New("at least one silence ID must be specified")
+	}
+
+	store, err := openSilenceStore(path)
+	if err != nil {
+		return err
+	}
+	for _, id := range flags.Args() {
+		if err := store.Expire(id, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,10 +1,18 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/lbzss/elasticsearch-alert/utils"
 	"github.com/shopspring/decimal"
@@ -18,64 +26,412 @@ const (
 	quantifierAll  = "all"
 	quantifierNone = "none"
 
+	// Count quantifiers are given as fields of their own, e.g.
+	// '"at_least": 5', instead of the 'quantifier' field
+	quantifierAtLeast        = "at_least"
+	quantifierAtMost         = "at_most"
+	quantifierExactly        = "exactly"
+	quantifierAtLeastPercent = "at_least_percent"
+	quantifierAtMostPercent  = "at_most_percent"
+
 	operatorEqual                = "eq"
 	operatorNotEqual             = "ne"
 	operatorLessThan             = "lt"
 	operatorLessThanOrEqualTo    = "le"
 	operatorGreaterThan          = "gt"
 	operatorGreaterThanOrEqualTo = "ge"
+	operatorMatch                = "match"
+	operatorNotMatch             = "not_match"
+	operatorContains             = "contains"
+	operatorIn                   = "in"
+	operatorNotIn                = "not_in"
+	operatorExists               = "exists"
+	operatorMissing              = "missing"
+	operatorStartsWith           = "starts_with"
+	operatorEndsWith             = "ends_with"
+	operatorOlderThan            = "older_than"
+	operatorNewerThan            = "newer_than"
+	operatorBefore               = "before"
+	operatorAfter                = "after"
+	operatorChanged              = "changed"
+	operatorIncreasedByPercent   = "increased_by_percent"
+	operatorDecreasedByPercent   = "decreased_by_percent"
+
+	// keyIgnoreCase makes the string operators case-insensitive
+	keyIgnoreCase = "ignore_case"
+
+	// keyTimeFormat is the format of the dates compared by the time
+	// operators, see parseTime
+	keyTimeFormat = "time_format"
+
+	// keyExpr makes a condition an expression over the whole response,
+	// e.g. 'aggregations.errors.doc_count / hits.total.value > 0.05'
+	keyExpr = "expr"
+
+	groupAllOf = "all_of"
+	groupAnyOf = "any_of"
+	groupNot   = "not"
+
+	// PreviousKey is the field of the response passed to ConditionsMet
+	// holding the response of the rule's previous run, if any. Conditions
+	// may refer to it, e.g. as 'previous.hits.total.value', and compare
+	// values to their previous ones with the 'changed',
+	// 'increased_by_percent' and 'decreased_by_percent' operators. The
+	// latter are met by changes of strictly more than the given percentage
+	PreviousKey = "previous"
 )
 
-type Condition map[string]interface{}
+// countQuantifiers are the quantifiers limiting the number of values
+// satisfying a condition
+var countQuantifiers = []string{
+	quantifierAtLeast,
+	quantifierAtMost,
+	quantifierExactly,
+	quantifierAtLeastPercent,
+	quantifierAtMostPercent,
+}
+
+// valueOperators are the operators checking the values found at the
+// field of a condition, in the order they are checked
+var valueOperators = []string{
+	operatorEqual,
+	operatorNotEqual,
+	operatorLessThan,
+	operatorLessThanOrEqualTo,
+	operatorGreaterThan,
+	operatorGreaterThanOrEqualTo,
+	operatorMatch,
+	operatorNotMatch,
+	operatorContains,
+	operatorStartsWith,
+	operatorEndsWith,
+	operatorIn,
+	operatorNotIn,
+	operatorOlderThan,
+	operatorNewerThan,
+	operatorBefore,
+	operatorAfter,
+	operatorChanged,
+	operatorIncreasedByPercent,
+	operatorDecreasedByPercent,
+}
+
+// Condition checks the values found at a field of the Elasticsearch
+// response, or combines other conditions if it is a group, i.e. has
+// exactly one of the keys 'all_of' or 'any_of', holding a list of
+// conditions, or 'not', holding a single condition. A condition may also
+// be an expression over the whole response, given as 'expr'. Numbers
+// are float64s in expressions, so unlike field conditions they compare
+// integers beyond 2^53 inexactly.
+//
+// Conditions are decoded as written and compiled by validate, which
+// checks the types of all operands, so that evaluating them cannot fail.
+// Conditions that have not been validated are never met.
+type Condition struct {
+	raw      map[string]interface{}
+	compiled bool
+
+	// Groups
+	group   string
+	members []Condition
+
+	// Expressions
+	code    string
+	program *vm.Program
+
+	// Field conditions
+	field      string
+	quantifier string
+	count      decimal.Decimal
+	exists     *bool
+	missing    *bool
+	ignoreCase bool
+	timeFormat string
+	checks     []check
+}
+
+// check is an operator with its operand, of which exactly one is set
+// depending on the operator and the type of the value configured.
+type check struct {
+	operator string
+	str      *string
+	number   *decimal.Decimal
+	boolean  *bool
+	re       *regexp.Regexp
+	strs     []string
+	numbers  []decimal.Decimal
+	interval time.Duration
+	date     *dateMath
+	changed  *bool
+}
+
+// previousValue is the value found at the same place in the response of
+// the rule's previous run as the value being checked, e.g. in the bucket
+// with the same key.
+type previousValue struct {
+	value interface{}
+	found bool
+}
+
+func (c *Condition) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
 
-func (c Condition) field() string {
-	return c[keyField].(string)
+	var raw map[string]interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		return errors.New("condition must be an object")
+	}
+	*c = Condition{raw: raw}
+	return nil
 }
 
-func (c Condition) quantifier() string {
-	return c[keyQuantifier].(string)
+func (c Condition) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.raw)
 }
 
-func (c Condition) validate() error {
+// ParseCondition returns the compiled condition described by raw, as
+// decoded from JSON with json.Number numbers.
+func ParseCondition(raw map[string]interface{}) (Condition, error) {
+	c := Condition{raw: raw}
+	err := c.validate()
+	return c, err
+}
+
+// String returns the condition as written in the rule.
+func (c Condition) String() string {
+	data, err := json.Marshal(c.raw)
+	if err != nil {
+		return fmt.Sprint(c.raw)
+	}
+	return string(data)
+}
+
+func (c *Condition) validate() error {
+	raw := c.raw
+	*c = Condition{raw: raw}
+	if raw == nil {
+		return errors.New("condition must not be empty")
+	}
+
+	var err error
+	if _, ok := raw[keyField]; ok {
+		err = c.compileField()
+	} else if _, ok := raw[keyExpr]; ok {
+		err = c.compileExpr()
+	} else if kind := groupKind(raw); kind != "" {
+		err = c.compileGroup(kind)
+	} else {
+		err = fmt.Errorf("condition must have the field '%s', '%s', '%s', '%s' or '%s'", keyField, keyExpr, groupAllOf, groupAnyOf, groupNot)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.compiled = true
+	return nil
+}
+
+func groupKind(raw map[string]interface{}) string {
+	for _, kind := range []string{groupAllOf, groupAnyOf, groupNot} {
+		if _, ok := raw[kind]; ok {
+			return kind
+		}
+	}
+	return ""
+}
+
+// compileGroup compiles the members of a group recursively.
+func (c *Condition) compileGroup(kind string) error {
+	if len(c.raw) != 1 {
+		return fmt.Errorf("condition groups must have exactly one of the fields '%s', '%s' or '%s' and nothing else", groupAllOf, groupAnyOf, groupNot)
+	}
+
+	var raws []interface{}
+	if kind == groupNot {
+		raws = []interface{}{c.raw[kind]}
+	} else {
+		var ok bool
+		if raws, ok = c.raw[kind].([]interface{}); !ok || len(raws) < 1 {
+			return fmt.Errorf("field '%s' of condition group must be a non-empty list of conditions", kind)
+		}
+	}
+
 	var allErrors *multierror.Error
-	if err := c.validateField(); err != nil {
+	members := make([]Condition, 0, len(raws))
+	for i, raw := range raws {
+		m, ok := raw.(map[string]interface{})
+		if !ok {
+			allErrors = multierror.Append(allErrors, fmt.Errorf("member %d of '%s' group must be a condition", i+1, kind))
+			continue
+		}
+
+		member, err := ParseCondition(m)
+		if err != nil {
+			allErrors = multierror.Append(allErrors, fmt.Errorf("error in member %d of '%s' group: %v", i+1, kind, err))
+		}
+		members = append(members, member)
+	}
+	if err := allErrors.ErrorOrNil(); err != nil {
+		return err
+	}
+
+	c.group = kind
+	c.members = members
+	return nil
+}
+
+func (c *Condition) compileExpr() error {
+	if len(c.raw) != 1 {
+		return fmt.Errorf("expression conditions must have the field '%s' and nothing else", keyExpr)
+	}
+
+	v, ok := c.raw[keyExpr].(string)
+	if !ok || v == "" {
+		return fmt.Errorf("field '%s' of condition must be a non-empty string", keyExpr)
+	}
+
+	program, err := expr.Compile(v, expr.Env(exprResponse{}), expr.AsBool())
+	if err != nil {
+		return fmt.Errorf("error compiling expression: %v", err)
+	}
+	c.code = v
+	c.program = program
+	return nil
+}
+
+func (c *Condition) compileField() error {
+	var allErrors *multierror.Error
+
+	known := map[string]bool{keyField: true, keyQuantifier: true, keyIgnoreCase: true, keyTimeFormat: true, operatorExists: true, operatorMissing: true}
+	for _, key := range countQuantifiers {
+		known[key] = true
+	}
+	for _, key := range valueOperators {
+		known[key] = true
+	}
+	unknown := make([]string, 0)
+	for key := range c.raw {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		allErrors = multierror.Append(allErrors, fmt.Errorf("unknown field '%s' in condition", key))
+	}
+
+	if err := c.compileFieldPath(); err != nil {
 		allErrors = multierror.Append(allErrors, err)
 	}
 
-	if err := c.validateQuantifier(); err != nil {
+	if err := c.compileQuantifier(); err != nil {
 		allErrors = multierror.Append(allErrors, err)
 	}
 
-	if errs := c.validateNumOperators(); len(errs) != 0 {
-		allErrors = multierror.Append(allErrors, errs...)
+	if raw, ok := c.raw[keyIgnoreCase]; ok {
+		v, ok := raw.(bool)
+		if !ok {
+			allErrors = multierror.Append(allErrors, fmt.Errorf("field '%s' of condition must be a boolean", keyIgnoreCase))
+		}
+		c.ignoreCase = v
 	}
 
-	if errs := c.validateMultiOperators(); len(errs) != 0 {
-		allErrors = multierror.Append(allErrors, errs...)
+	if raw, ok := c.raw[keyTimeFormat]; ok {
+		v, ok := raw.(string)
+		if !ok || v == "" {
+			allErrors = multierror.Append(allErrors, fmt.Errorf("field '%s' of condition must be a non-empty string", keyTimeFormat))
+		} else if err := validateTimeFormat(v); err != nil {
+			allErrors = multierror.Append(allErrors, err)
+		}
+		c.timeFormat = v
 	}
-	return allErrors.ErrorOrNil()
-}
 
-func (c Condition) validateField() error {
-	raw, ok := c[keyField]
-	if !ok {
-		return errors.New("condition must have the field 'field'")
+	for _, operator := range []string{operatorExists, operatorMissing} {
+		raw, ok := c.raw[operator]
+		if !ok {
+			continue
+		}
+		v, ok := raw.(bool)
+		if !ok {
+			allErrors = multierror.Append(allErrors, fmt.Errorf("value of operator '%s' should be a boolean", operator))
+			continue
+		}
+		if operator == operatorExists {
+			c.exists = &v
+		} else {
+			c.missing = &v
+		}
 	}
 
-	v, ok := raw.(string)
+	for _, operator := range valueOperators {
+		raw, ok := c.raw[operator]
+		if !ok {
+			continue
+		}
+		chk, err := c.compileCheck(operator, raw)
+		if err != nil {
+			allErrors = multierror.Append(allErrors, err)
+			continue
+		}
+		c.checks = append(c.checks, chk)
+	}
+	return allErrors.ErrorOrNil()
+}
+
+func (c *Condition) compileFieldPath() error {
+	v, ok := c.raw[keyField].(string)
 	if !ok || v == "" {
 		return errors.New("field 'field' of condition must not be empty")
 	}
-
+	if err := utils.ValidatePath(v); err != nil {
+		return err
+	}
+	c.field = v
 	return nil
 }
 
-func (c Condition) validateQuantifier() error {
-	raw, ok := c[keyQuantifier]
+func (c *Condition) compileQuantifier() error {
+	for _, quantifier := range countQuantifiers {
+		raw, ok := c.raw[quantifier]
+		if !ok {
+			continue
+		}
+		if c.quantifier != "" {
+			return errors.New("condition must have at most one quantifier")
+		}
+
+		d, err := parseNumber(raw)
+		if err != nil || d.IsNegative() {
+			return fmt.Errorf("value of quantifier '%s' should be a non-negative number", quantifier)
+		}
+
+		switch quantifier {
+		case quantifierAtLeastPercent, quantifierAtMostPercent:
+			if d.GreaterThan(decimal.NewFromInt(100)) {
+				return fmt.Errorf("value of quantifier '%s' should not be greater than 100", quantifier)
+			}
+		default:
+			if !d.IsInteger() {
+				return fmt.Errorf("value of quantifier '%s' should be an integer", quantifier)
+			}
+		}
+		c.quantifier = quantifier
+		c.count = d
+	}
+
+	raw, ok := c.raw[keyQuantifier]
 	if !ok {
-		c[keyQuantifier] = quantifierAny
+		if c.quantifier == "" {
+			c.quantifier = quantifierAny
+		}
 		return nil
 	}
+	if c.quantifier != "" {
+		return errors.New("condition must have at most one quantifier")
+	}
 
 	v, ok := raw.(string)
 	if !ok {
@@ -85,193 +441,614 @@ func (c Condition) validateQuantifier() error {
 	if v != quantifierAny && v != quantifierAll && v != quantifierNone {
 		return errors.New("field 'quantifier' of condition must either be 'any', 'all', or 'none'")
 	}
-
+	c.quantifier = v
 	return nil
 }
 
-func (c Condition) validateNumOperators() []error {
-	numOperators := []string{
-		operatorLessThanOrEqualTo,
-		operatorLessThan,
-		operatorGreaterThan,
-		operatorGreaterThanOrEqualTo,
+func (c *Condition) compileCheck(operator string, raw interface{}) (check, error) {
+	chk := check{operator: operator}
+	switch operator {
+	case operatorEqual, operatorNotEqual:
+		switch v := raw.(type) {
+		case json.Number:
+			d, err := parseNumber(v)
+			if err != nil {
+				return chk, fmt.Errorf("value of operator '%s' is not a valid number: %v", operator, err)
+			}
+			chk.number = &d
+		case string:
+			if v == "" {
+				return chk, fmt.Errorf("value of operator '%s' should not be empty", operator)
+			}
+			s := c.fold(v)
+			chk.str = &s
+		case bool:
+			chk.boolean = &v
+		default:
+			return chk, fmt.Errorf("value of operator '%s' should either be a number, a string or a boolean", operator)
+		}
+	case operatorLessThan, operatorLessThanOrEqualTo, operatorGreaterThan, operatorGreaterThanOrEqualTo:
+		d, err := parseNumber(raw)
+		if err != nil {
+			return chk, fmt.Errorf("value of operator '%s' should be a number", operator)
+		}
+		chk.number = &d
+	case operatorMatch, operatorNotMatch:
+		v, ok := raw.(string)
+		if !ok || v == "" {
+			return chk, fmt.Errorf("value of operator '%s' should be a non-empty string", operator)
+		}
+		if c.ignoreCase {
+			v = "(?i)" + v
+		}
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return chk, fmt.Errorf("error compiling pattern of operator '%s': %v", operator, err)
+		}
+		chk.re = re
+	case operatorContains, operatorStartsWith, operatorEndsWith:
+		v, ok := raw.(string)
+		if !ok || v == "" {
+			return chk, fmt.Errorf("value of operator '%s' should be a non-empty string", operator)
+		}
+		s := c.fold(v)
+		chk.str = &s
+	case operatorOlderThan, operatorNewerThan:
+		v, ok := raw.(string)
+		if !ok {
+			return chk, fmt.Errorf("value of operator '%s' should be an interval, e.g. '15m' or '1d'", operator)
+		}
+		d, err := parseInterval(v)
+		if err != nil || d <= 0 {
+			return chk, fmt.Errorf("value of operator '%s' should be a positive interval, e.g. '15m' or '1d'", operator)
+		}
+		chk.interval = d
+	case operatorBefore, operatorAfter:
+		v, ok := raw.(string)
+		if !ok || v == "" {
+			return chk, fmt.Errorf("value of operator '%s' should be a date or date math, e.g. 'now-1h'", operator)
+		}
+		d, err := parseDateMath(v, c.timeFormat)
+		if err != nil {
+			return chk, fmt.Errorf("error in value of operator '%s': %v", operator, err)
+		}
+		chk.date = d
+	case operatorChanged:
+		v, ok := raw.(bool)
+		if !ok {
+			return chk, fmt.Errorf("value of operator '%s' should be a boolean", operator)
+		}
+		chk.changed = &v
+	case operatorIncreasedByPercent, operatorDecreasedByPercent:
+		d, err := parseNumber(raw)
+		if err != nil || d.IsNegative() {
+			return chk, fmt.Errorf("value of operator '%s' should be a non-negative number", operator)
+		}
+		chk.number = &d
+	case operatorIn, operatorNotIn:
+		list, ok := raw.([]interface{})
+		if !ok || len(list) < 1 {
+			return chk, fmt.Errorf("value of operator '%s' should be a non-empty list", operator)
+		}
+		for _, elem := range list {
+			switch v := elem.(type) {
+			case string:
+				chk.strs = append(chk.strs, c.fold(v))
+			case json.Number:
+				d, err := parseNumber(v)
+				if err != nil {
+					return chk, fmt.Errorf("value of operator '%s' contains an invalid number: %v", operator, err)
+				}
+				chk.numbers = append(chk.numbers, d)
+			default:
+				return chk, fmt.Errorf("values of operator '%s' should either be numbers or strings", operator)
+			}
+		}
 	}
+	return chk, nil
+}
 
-	errors := make([]error, 0)
-	for _, operator := range numOperators {
-		if raw, ok := c[operator]; ok {
-			if v, ok := raw.(json.Number); !ok {
-				errors = append(errors, fmt.Errorf("value of operator '%s' should be a number", operator))
-			} else if v.String() == "" {
-				errors = append(errors, fmt.Errorf("value of operator '%s' should not be empty", operator))
-			}
+// fold lower-cases s if the condition ignores case. As 'ignore_case' is
+// compiled before the operators, fold may be used while compiling them.
+func (c *Condition) fold(s string) string {
+	if c.ignoreCase {
+		return strings.ToLower(s)
+	}
+	return s
+}
+
+func parseNumber(raw interface{}) (decimal.Decimal, error) {
+	v, ok := raw.(json.Number)
+	if !ok {
+		return decimal.Zero, errors.New("not a number")
+	}
+	return decimal.NewFromString(v.String())
+}
+
+// ConditionsMet reports whether all conditions are met by the response,
+// i.e. the list of conditions of a rule is an implicit 'all_of' group.
+func ConditionsMet(resp map[string]interface{}, conditions []Condition) bool {
+	for _, condition := range conditions {
+		if !condition.evaluate(resp, nil) {
+			return false
 		}
 	}
+	return true
+}
 
-	return errors
+// ExplainConditions evaluates conditions like ConditionsMet and returns
+// a trace of the evaluation of each of them. Unlike ConditionsMet it
+// evaluates every condition, even once one is not met.
+func ExplainConditions(resp map[string]interface{}, conditions []Condition) (bool, []*Trace) {
+	met := true
+	traces := make([]*Trace, 0, len(conditions))
+	for _, condition := range conditions {
+		trace := new(Trace)
+		met = condition.evaluate(resp, trace) && met
+		traces = append(traces, trace)
+	}
+	return met, traces
 }
 
-func (c Condition) validateMultiOperators() []error {
-	strOrNumOperators := []string{
-		operatorEqual,
-		operatorNotEqual,
+// evaluate reports whether the condition is met by the response and, if
+// trace is not nil, records how it came to that result in trace.
+func (c Condition) evaluate(resp map[string]interface{}, trace *Trace) (met bool) {
+	if trace != nil {
+		trace.Condition = c.raw
+		defer func() { trace.Met = met }()
 	}
 
-	errors := make([]error, 0)
-	for _, operator := range strOrNumOperators {
-		if raw, ok := c[operator]; ok {
-			switch v := raw.(type) {
-			case json.Number:
-				if string(v) == "" {
-					errors = append(errors, fmt.Errorf("value of operator '%s' should not be empty", operator))
-				}
-			case string:
-				if v == "" {
-					errors = append(errors, fmt.Errorf("value of operator '%s' should not be empty", operator))
-				}
-			default:
-				errors = append(errors, fmt.Errorf("value of operator '%s' should either be a number or a string", operator))
+	if !c.compiled {
+		fmt.Println("condition was not validated, treating it as not met", "condition", c.String())
+		if trace != nil {
+			trace.Error = "condition was not validated"
+		}
+		return false
+	}
+
+	if c.group != "" {
+		return c.evaluateGroup(resp, trace)
+	}
+
+	if c.program != nil {
+		met, err := exprMet(resp, c.program)
+		if err != nil {
+			fmt.Println("error evaluating expression", "expr", c.code, "error", err)
+			if trace != nil {
+				trace.Error = err.Error()
 			}
 		}
+		return met
 	}
 
-	return errors
-}
+	found := utils.Find(resp, c.field)
+	matches := make([]interface{}, 0, len(found))
+	for _, m := range found {
+		matches = append(matches, m.Value)
+	}
 
-func ConditionsMet(resp map[string]interface{}, conditions []Condition) bool {
-	for _, condition := range conditions {
-		matches := utils.GetAll(resp, condition.field())
+	if trace != nil {
+		trace.Field = c.field
+		trace.Quantifier = c.quantifier
+		if c.quantifier != quantifierAny && c.quantifier != quantifierAll && c.quantifier != quantifierNone {
+			trace.Quantifier = c.quantifier + " " + c.count.String()
+		}
+		trace.Values = make([]*ValueTrace, 0, len(found))
+	}
 
-		res := false
-		switch condition.quantifier() {
-		case quantifierAll:
-			res = allSatisfied(matches, condition)
-		case quantifierAny:
-			res = anySatisfied(matches, condition)
-		case quantifierNone:
-			res = noneSatisfied(matches, condition)
+	if !c.present(matches) {
+		if trace != nil {
+			trace.Error = "field is missing or present contrary to 'exists'/'missing'"
 		}
+		return false
+	}
+	if c.missing != nil && *c.missing {
+		return true
+	}
 
-		if !res {
-			return false
+	var previous map[string]previousValue
+	if c.comparesPrevious() {
+		previous = previousValues(resp, c.field)
+		if previous == nil && trace != nil {
+			trace.Error = "no previous run to compare values to"
 		}
 	}
-	return true
-}
 
-func allSatisfied(matches []interface{}, condition Condition) bool {
-	for _, match := range matches {
-		sat := satisfied(match, condition)
-		if !sat {
-			return false
+	count := 0
+	for i, match := range matches {
+		var prev *previousValue
+		if previous != nil {
+			p := previous[matchID(found[i])]
+			prev = &p
+		}
+
+		var vt *ValueTrace
+		if trace != nil {
+			vt = &ValueTrace{Path: found[i].Path, Value: match}
+			if prev != nil {
+				vt.Previous = prev.value
+			}
+			trace.Values = append(trace.Values, vt)
+		}
+		if satisfied(match, prev, c, vt) {
+			count++
 		}
 	}
-	return true
+	return c.quantified(count, len(matches))
 }
 
-func anySatisfied(matches []interface{}, condition Condition) bool {
-	for _, match := range matches {
-		sat := satisfied(match, condition)
-		if sat {
+func (c Condition) comparesPrevious() bool {
+	for _, chk := range c.checks {
+		switch chk.operator {
+		case operatorChanged, operatorIncreasedByPercent, operatorDecreasedByPercent:
 			return true
 		}
 	}
 	return false
 }
 
-func noneSatisfied(matches []interface{}, condition Condition) bool {
+// previousValues returns the values at field in the previous response
+// by matchID, or nil if there is no previous response.
+func previousValues(resp map[string]interface{}, field string) map[string]previousValue {
+	previous, ok := resp[PreviousKey].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	values := make(map[string]previousValue)
+	for _, m := range utils.Find(previous, field) {
+		values[matchID(m)] = previousValue{value: m.Value, found: true}
+	}
+	return values
+}
+
+// matchID identifies a value across runs: values within buckets by the
+// keys of the buckets, as their order may change, and others by path.
+func matchID(m utils.Match) string {
+	if len(m.Keys) > 0 {
+		return strings.Join(m.Keys, "\x00")
+	}
+	return m.Path
+}
+
+func (c Condition) evaluateGroup(resp map[string]interface{}, trace *Trace) bool {
+	results := make([]bool, 0, len(c.members))
+	for _, member := range c.members {
+		var mt *Trace
+		if trace != nil {
+			mt = new(Trace)
+			trace.Members = append(trace.Members, mt)
+		}
+		met := member.evaluate(resp, mt)
+		results = append(results, met)
+
+		// Without a trace the rest of the members need not be evaluated
+		if trace == nil && (c.group == groupAllOf && !met || c.group == groupAnyOf && met) {
+			break
+		}
+	}
+	if trace != nil {
+		trace.Group = c.group
+	}
+
+	switch c.group {
+	case groupAllOf:
+		for _, met := range results {
+			if !met {
+				return false
+			}
+		}
+		return true
+	case groupAnyOf:
+		for _, met := range results {
+			if met {
+				return true
+			}
+		}
+		return false
+	default:
+		return !results[0]
+	}
+}
+
+// quantified reports whether count of total values satisfying the
+// condition is enough according to its quantifier.
+func (c Condition) quantified(count, total int) bool {
+	n := decimal.NewFromInt(int64(count))
+	switch c.quantifier {
+	case quantifierAll:
+		return count == total
+	case quantifierAny:
+		return count > 0
+	case quantifierNone:
+		return count == 0
+	case quantifierAtLeast:
+		return n.GreaterThanOrEqual(c.count)
+	case quantifierAtMost:
+		return n.LessThanOrEqual(c.count)
+	case quantifierExactly:
+		return n.Equal(c.count)
+	}
+
+	// Percentages are of the values found, of which there must be some
+	if total < 1 {
+		return false
+	}
+	percent := n.Mul(decimal.NewFromInt(100)).Div(decimal.NewFromInt(int64(total)))
+	if c.quantifier == quantifierAtLeastPercent {
+		return percent.GreaterThanOrEqual(c.count)
+	}
+	return percent.LessThanOrEqual(c.count)
+}
+
+// present reports whether the condition's 'exists' and 'missing'
+// operators are satisfied by the values found for its field.
+func (c Condition) present(matches []interface{}) bool {
+	found := false
 	for _, match := range matches {
-		sat := satisfied(match, condition)
-		if sat {
-			return false
+		if match != nil {
+			found = true
+			break
 		}
 	}
+
+	if c.exists != nil && *c.exists != found {
+		return false
+	}
+	if c.missing != nil && *c.missing == found {
+		return false
+	}
 	return true
 }
 
-func satisfied(match interface{}, condition Condition) bool {
-	switch v := match.(type) {
-	case string:
-		return stringSatisfied(v, condition)
-	case json.Number:
-		return numberSatisfied(v, condition)
-	case bool:
-		return boolSatisfied(v, condition)
-	default:
-		fields := make([]interface{}, 0, 4)
-		if f, ok := condition[keyField].(string); ok {
-			fields = append(fields, f)
-		}
+// satisfied reports whether a value passes all checks of the condition
+// and, if trace is not nil, records the result of every check in it.
+// Every operator expects values of the type of its operand, e.g. a
+// string 'eq' is never satisfied by a number.
+func satisfied(match interface{}, prev *previousValue, condition Condition, trace *ValueTrace) (sat bool) {
+	if trace != nil {
+		defer func() { trace.Satisfied = sat }()
+	}
+
+	if len(condition.checks) < 1 {
+		return true
+	}
 
+	switch match.(type) {
+	case string, json.Number, bool, []interface{}:
+	case nil:
+		// Null values, e.g. of metric aggregations over no documents,
+		// are missing and satisfy no check
+		return false
+	case map[string]interface{}:
+		fields := []interface{}{condition.field}
 		if d, err := json.Marshal(match); err == nil {
 			fields = append(fields, "value", string(d))
 		} else {
 			fields = append(fields, "value", match)
 		}
 
-		fmt.Printf("Value of field in Elasticsearch response is not a string, number, or boolean. Ignoring condition for this value, %s", fields...)
+		fmt.Println("Value of field in Elasticsearch response is not a string, number, boolean or list. Ignoring condition for this value", fields)
+		if trace != nil {
+			trace.Ignored = true
+		}
+		return true
+	default:
+		// Responses are decoded with json.Numbers, other types, e.g.
+		// float64s, would be compared inexactly if at all
+		fmt.Println("Value of field in Elasticsearch response is not a decoded JSON value. Condition is not satisfied by this value", []interface{}{condition.field, "type", fmt.Sprintf("%T", match)})
+		return false
 	}
-	return true
-}
 
-func stringSatisfied(s string, condition Condition) bool {
-	sat := true
-
-	if v, ok := condition[operatorEqual].(string); ok && v != "" {
-		sat = sat && s == v
+	sat = true
+	for _, chk := range condition.checks {
+		ok := chk.satisfied(match, prev, condition)
+		if trace != nil {
+			trace.Operators = append(trace.Operators, &OperatorTrace{Operator: chk.operator, Met: ok})
+		} else if !ok {
+			return false
+		}
+		sat = sat && ok
 	}
+	return sat
+}
 
-	if v, ok := condition[operatorNotEqual].(string); ok && v != "" {
-		sat = sat && s != v
+func (chk check) satisfied(match interface{}, prev *previousValue, condition Condition) bool {
+	switch chk.operator {
+	case operatorOlderThan, operatorNewerThan, operatorBefore, operatorAfter:
+		return chk.timeSatisfied(match, condition)
+	case operatorChanged, operatorIncreasedByPercent, operatorDecreasedByPercent:
+		return chk.previousSatisfied(match, prev)
 	}
 
-	return sat
+	switch v := match.(type) {
+	case string:
+		return chk.stringSatisfied(v, condition)
+	case json.Number:
+		d, err := decimal.NewFromString(v.String())
+		if err != nil {
+			return false
+		}
+		return chk.numberSatisfied(d)
+	case bool:
+		return chk.boolSatisfied(v)
+	case []interface{}:
+		return chk.listSatisfied(v, condition)
+	}
+	return false
 }
 
-func boolSatisfied(b bool, condition Condition) bool {
-	sat := true
+func (chk check) stringSatisfied(s string, condition Condition) bool {
+	folded := condition.fold(s)
+	switch chk.operator {
+	case operatorEqual:
+		return chk.str != nil && folded == *chk.str
+	case operatorNotEqual:
+		return chk.str != nil && folded != *chk.str
+	case operatorContains:
+		return strings.Contains(folded, *chk.str)
+	case operatorStartsWith:
+		return strings.HasPrefix(folded, *chk.str)
+	case operatorEndsWith:
+		return strings.HasSuffix(folded, *chk.str)
+	case operatorMatch:
+		// Patterns are matched against the original value, they are
+		// made case-insensitive when compiled
+		return chk.re.MatchString(s)
+	case operatorNotMatch:
+		return !chk.re.MatchString(s)
+	case operatorIn, operatorNotIn:
+		in := false
+		for _, v := range chk.strs {
+			if v == folded {
+				in = true
+				break
+			}
+		}
+		return in == (chk.operator == operatorIn)
+	}
+	return false
+}
 
-	if v, ok := condition[operatorEqual].(bool); ok {
-		sat = sat && b == v
+func (chk check) numberSatisfied(d decimal.Decimal) bool {
+	switch chk.operator {
+	case operatorEqual:
+		return chk.number != nil && d.Equal(*chk.number)
+	case operatorNotEqual:
+		return chk.number != nil && !d.Equal(*chk.number)
+	case operatorLessThan:
+		return d.LessThan(*chk.number)
+	case operatorLessThanOrEqualTo:
+		return d.LessThanOrEqual(*chk.number)
+	case operatorGreaterThan:
+		return d.GreaterThan(*chk.number)
+	case operatorGreaterThanOrEqualTo:
+		return d.GreaterThanOrEqual(*chk.number)
+	case operatorIn, operatorNotIn:
+		in := false
+		for _, v := range chk.numbers {
+			if d.Equal(v) {
+				in = true
+				break
+			}
+		}
+		return in == (chk.operator == operatorIn)
 	}
+	return false
+}
 
-	if v, ok := condition[operatorNotEqual].(bool); ok {
-		sat = sat && b == v
+// timeSatisfied compares a date to the current time. Strings are parsed
+// with the condition's time format and numbers are epoch milliseconds,
+// or seconds if the format is 'epoch_second'.
+func (chk check) timeSatisfied(match interface{}, condition Condition) bool {
+	var t time.Time
+	switch v := match.(type) {
+	case string:
+		var err error
+		if t, err = parseTime(v, condition.timeFormat); err != nil {
+			return false
+		}
+	case json.Number:
+		if condition.timeFormat != "" && condition.timeFormat != timeFormatEpochMillis && condition.timeFormat != timeFormatEpochSecond {
+			return false
+		}
+		n, err := v.Float64()
+		if err != nil {
+			return false
+		}
+		t = epochTime(n, condition.timeFormat)
+	default:
+		return false
 	}
 
-	return sat
+	now := timeNow()
+	switch chk.operator {
+	case operatorOlderThan:
+		return t.Before(now.Add(-chk.interval))
+	case operatorNewerThan:
+		return !t.Before(now.Add(-chk.interval))
+	case operatorBefore:
+		return t.Before(chk.date.at(now))
+	case operatorAfter:
+		return t.After(chk.date.at(now))
+	}
+	return false
 }
 
-func numberSatisfied(k json.Number, condition Condition) bool {
-	d := decimal.RequireFromString(k.String())
-
-	dec := decimal.RequireFromString
+// previousSatisfied compares a value to its previous one. Without a
+// previous run no comparison is satisfied. Values that had no previous
+// one have changed, but neither increased nor decreased.
+func (chk check) previousSatisfied(match interface{}, prev *previousValue) bool {
+	if prev == nil {
+		return false
+	}
 
-	sat := true
+	if chk.operator == operatorChanged {
+		changed := !prev.found || !sameValue(match, prev.value)
+		return changed == *chk.changed
+	}
 
-	if v, ok := condition[operatorEqual].(json.Number); ok {
-		sat = sat && d.Equal(dec(string(v)))
+	if !prev.found {
+		return false
+	}
+	cur, ok := toDecimal(match)
+	if !ok {
+		return false
+	}
+	old, ok := toDecimal(prev.value)
+	if !ok {
+		return false
 	}
 
-	if v, ok := condition[operatorNotEqual].(json.Number); ok {
-		sat = sat && !d.Equal(dec(string(v)))
+	// The change in percent of the previous value, without dividing by
+	// it as it may be zero
+	diff := cur.Sub(old)
+	if chk.operator == operatorDecreasedByPercent {
+		diff = diff.Neg()
 	}
+	return diff.IsPositive() && diff.Mul(decimal.NewFromInt(100)).GreaterThan(chk.number.Mul(old.Abs()))
+}
 
-	if v, ok := condition[operatorLessThan].(json.Number); ok {
-		sat = sat && d.LessThan(dec(string(v)))
+func toDecimal(v interface{}) (decimal.Decimal, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return decimal.Zero, false
 	}
+	d, err := decimal.NewFromString(n.String())
+	return d, err == nil
+}
 
-	if v, ok := condition[operatorLessThanOrEqualTo].(json.Number); ok {
-		sat = sat && d.LessThanOrEqual(dec(string(v)))
+// sameValue compares two values of a response, numbers by value.
+func sameValue(a, b interface{}) bool {
+	if x, ok := toDecimal(a); ok {
+		y, ok := toDecimal(b)
+		return ok && x.Equal(y)
 	}
+	return reflect.DeepEqual(a, b)
+}
 
-	if v, ok := condition[operatorGreaterThan].(json.Number); ok {
-		sat = sat && d.GreaterThan(dec(string(v)))
+func (chk check) boolSatisfied(b bool) bool {
+	switch chk.operator {
+	case operatorEqual:
+		return chk.boolean != nil && b == *chk.boolean
+	case operatorNotEqual:
+		return chk.boolean != nil && b != *chk.boolean
 	}
+	return false
+}
 
-	if v, ok := condition[operatorGreaterThanOrEqualTo].(json.Number); ok {
-		sat = sat && d.GreaterThanOrEqual(dec(string(v)))
+// listSatisfied checks the 'contains' operator against the elements of a
+// list value, e.g. the tags of a document. Lists satisfy no other
+// operator.
+func (chk check) listSatisfied(l []interface{}, condition Condition) bool {
+	if chk.operator != operatorContains {
+		return false
 	}
 
-	return sat
+	for _, elem := range l {
+		if s, ok := elem.(string); ok && condition.fold(s) == *chk.str {
+			return true
+		}
+	}
+	return false
 }
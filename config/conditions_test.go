@@ -0,0 +1,419 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, data string, v interface{}) {
+	t.Helper()
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const conditionsResponse = `{"hits": {"hits": [
+	{"_source": {"message": "Connection REFUSED by upstream", "status": 503, "tags": ["prod", "web"]}},
+	{"_source": {"message": "request served", "status": 200, "tags": ["prod"]}}
+]}}`
+
+func TestConditionOperators(t *testing.T) {
+	var resp map[string]interface{}
+	decodeJSON(t, conditionsResponse, &resp)
+
+	cases := []struct {
+		condition string
+		met       bool
+	}{
+		{`{"field": "hits.hits._source.message", "match": "refused|timeout", "ignore_case": true}`, true},
+		{`{"field": "hits.hits._source.message", "match": "refused|timeout"}`, false},
+		{`{"field": "hits.hits._source.message", "quantifier": "all", "not_match": "^panic"}`, true},
+		{`{"field": "hits.hits._source.message", "contains": "upstream"}`, true},
+		{`{"field": "hits.hits._source.message", "starts_with": "connection", "ignore_case": true}`, true},
+		{`{"field": "hits.hits._source.message", "ends_with": "served"}`, true},
+		{`{"field": "hits.hits._source.status", "in": [500, 502, 503]}`, true},
+		{`{"field": "hits.hits._source.status", "quantifier": "all", "not_in": [500, 502]}`, true},
+		{`{"field": "hits.hits._source.message", "in": ["request SERVED"], "ignore_case": true}`, true},
+		{`{"field": "hits.hits._source.tags", "contains": "web"}`, true},
+		{`{"field": "hits.hits._source.tags", "quantifier": "all", "contains": "web"}`, false},
+		{`{"field": "hits.hits._source.status", "exists": true}`, true},
+		{`{"field": "hits.hits._source.user", "exists": true}`, false},
+		{`{"field": "hits.hits._source.user", "missing": true}`, true},
+		{`{"field": "hits.hits._source.status", "missing": true}`, false},
+	}
+
+	for _, c := range cases {
+		var condition Condition
+		decodeJSON(t, c.condition, &condition)
+		if err := condition.validate(); err != nil {
+			t.Fatalf("condition %s should be valid: %v", c.condition, err)
+		}
+		if met := ConditionsMet(resp, []Condition{condition}); met != c.met {
+			t.Errorf("expected condition %s to evaluate to %t", c.condition, c.met)
+		}
+	}
+}
+
+func TestConditionOperatorsValidation(t *testing.T) {
+	invalid := []string{
+		`{"field": "message", "match": "("}`,
+		`{"field": "message", "contains": 3}`,
+		`{"field": "status", "in": []}`,
+		`{"field": "status", "in": [true]}`,
+		`{"field": "status", "exists": "yes"}`,
+		`{"field": "message", "eq": "x", "ignore_case": "true"}`,
+	}
+	for _, data := range invalid {
+		var condition Condition
+		decodeJSON(t, data, &condition)
+		if err := condition.validate(); err == nil {
+			t.Errorf("expected condition %s to be invalid", data)
+		}
+	}
+}
+
+func TestConditionGroups(t *testing.T) {
+	var resp map[string]interface{}
+	decodeJSON(t, conditionsResponse, &resp)
+
+	cases := []struct {
+		conditions string
+		met        bool
+	}{
+		{`[{"any_of": [
+			{"field": "hits.hits._source.status", "gt": 500, "quantifier": "all"},
+			{"field": "hits.hits._source.message", "contains": "refused", "ignore_case": true}
+		]}]`, true},
+		{`[{"all_of": [
+			{"field": "hits.hits._source.status", "eq": 503},
+			{"not": {"field": "hits.hits._source.tags", "contains": "web"}}
+		]}]`, false},
+		{`[
+			{"field": "hits.hits._source.status", "eq": 200},
+			{"not": {"any_of": [
+				{"field": "hits.hits._source.user", "exists": true},
+				{"field": "hits.hits._source.status", "ge": 600}
+			]}}
+		]`, true},
+	}
+
+	for _, c := range cases {
+		var conditions []Condition
+		decodeJSON(t, c.conditions, &conditions)
+		for i := range conditions {
+			if err := conditions[i].validate(); err != nil {
+				t.Fatalf("conditions %s should be valid: %v", c.conditions, err)
+			}
+		}
+		if met := ConditionsMet(resp, conditions); met != c.met {
+			t.Errorf("expected conditions %s to evaluate to %t", c.conditions, c.met)
+		}
+	}
+
+	invalid := []string{
+		`{"any_of": []}`,
+		`{"all_of": [{"field": "status", "gt": "high"}]}`,
+		`{"not": [{"field": "status", "eq": 1}]}`,
+		`{"any_of": [{"field": "status", "eq": 1}], "not": {"field": "status", "eq": 2}}`,
+	}
+	for _, data := range invalid {
+		var condition Condition
+		decodeJSON(t, data, &condition)
+		if err := condition.validate(); err == nil {
+			t.Errorf("expected condition %s to be invalid", data)
+		}
+	}
+}
+
+func TestConditionCountQuantifiers(t *testing.T) {
+	var resp map[string]interface{}
+	decodeJSON(t, `{"aggregations": {"hosts": {"buckets": [
+		{"key": "web-1", "doc_count": 120},
+		{"key": "web-2", "doc_count": 90},
+		{"key": "web-3", "doc_count": 150},
+		{"key": "web-4", "doc_count": 10},
+		{"key": "web-5", "doc_count": 5}
+	]}}}`, &resp)
+
+	cases := []struct {
+		condition string
+		met       bool
+	}{
+		{`{"field": "aggregations.hosts.buckets.doc_count", "gt": 80, "at_least": 3}`, true},
+		{`{"field": "aggregations.hosts.buckets.doc_count", "gt": 80, "at_least": 4}`, false},
+		{`{"field": "aggregations.hosts.buckets.doc_count", "gt": 100, "at_most": 2}`, true},
+		{`{"field": "aggregations.hosts.buckets.doc_count", "gt": 100, "exactly": 1}`, false},
+		{`{"field": "aggregations.hosts.buckets.doc_count", "lt": 50, "at_least_percent": 40}`, true},
+		{`{"field": "aggregations.hosts.buckets.doc_count", "lt": 50, "at_most_percent": 20}`, false},
+		{`{"field": "aggregations.missing.buckets.doc_count", "gt": 0, "at_most_percent": 100}`, false},
+	}
+
+	for _, c := range cases {
+		var condition Condition
+		decodeJSON(t, c.condition, &condition)
+		if err := condition.validate(); err != nil {
+			t.Fatalf("condition %s should be valid: %v", c.condition, err)
+		}
+		if met := ConditionsMet(resp, []Condition{condition}); met != c.met {
+			t.Errorf("expected condition %s to evaluate to %t", c.condition, c.met)
+		}
+	}
+
+	invalid := []string{
+		`{"field": "doc_count", "gt": 1, "at_least": -1}`,
+		`{"field": "doc_count", "gt": 1, "at_least": 1.5}`,
+		`{"field": "doc_count", "gt": 1, "at_least_percent": 120}`,
+		`{"field": "doc_count", "gt": 1, "at_least": 1, "at_most": 3}`,
+		`{"field": "doc_count", "gt": 1, "at_least": 1, "quantifier": "all"}`,
+	}
+	for _, data := range invalid {
+		var condition Condition
+		decodeJSON(t, data, &condition)
+		if err := condition.validate(); err == nil {
+			t.Errorf("expected condition %s to be invalid", data)
+		}
+	}
+}
+
+func TestConditionExpr(t *testing.T) {
+	var resp map[string]interface{}
+	decodeJSON(t, `{
+		"_shards": {"total": 2, "successful": 2, "skipped": 0, "failed": 0},
+		"hits": {"total": {"value": 1000, "relation": "eq"}, "max_score": null},
+		"aggregations": {"errors": {"doc_count": 80}, "latency": {"values": {"99.0": 2400}}}
+	}`, &resp)
+
+	cases := []struct {
+		condition string
+		met       bool
+	}{
+		{`{"expr": "aggregations.errors.doc_count / hits.total.value > 0.05"}`, true},
+		{`{"expr": "aggregations.errors.doc_count > 100 || aggregations.latency.values['99.0'] > 2000"}`, true},
+		{`{"expr": "aggregations.errors.doc_count > hits.total.value"}`, false},
+		{`{"expr": "aggregations.missing.doc_count > 1"}`, false},
+		{`{"expr": "hits.total.relation == 'eq' && _shards.failed == 0"}`, true},
+		{`{"expr": "previous.hits.total.value > 0"}`, false},
+	}
+	for _, c := range cases {
+		var condition Condition
+		decodeJSON(t, c.condition, &condition)
+		if err := condition.validate(); err != nil {
+			t.Fatalf("condition %s should be valid: %v", c.condition, err)
+		}
+		if met := ConditionsMet(resp, []Condition{condition}); met != c.met {
+			t.Errorf("expected condition %s to evaluate to %t", c.condition, c.met)
+		}
+	}
+
+	invalid := []string{
+		`{"expr": ""}`,
+		`{"expr": "aggregatons.errors.doc_count > 1"}`,
+		`{"expr": "hits.total.value >"}`,
+		`{"expr": "'errors' + 1 > 2"}`,
+		`{"expr": "hits.total.value > 1", "field": "hits.total.value"}`,
+		`{"expr": "hits.total.value > 'x'"}`,
+		`{"expr": "hits.totl.value > 1"}`,
+		`{"expr": "_shards.failed == true"}`,
+		`{"expr": "previous.hits.total.relation > 1"}`,
+	}
+	for _, data := range invalid {
+		var condition Condition
+		decodeJSON(t, data, &condition)
+		if err := condition.validate(); err == nil {
+			t.Errorf("expected condition %s to be invalid", data)
+		}
+	}
+}
+
+func TestConditionPrevious(t *testing.T) {
+	var resp map[string]interface{}
+	decodeJSON(t, `{
+		"hits": {"total": {"value": 100}},
+		"aggregations": {"hosts": {"buckets": [
+			{"key": "web-1", "doc_count": 160},
+			{"key": "web-2", "doc_count": 20},
+			{"key": "web-3", "doc_count": 5}
+		]}},
+		"previous": {
+			"hits": {"total": {"value": 100}},
+			"aggregations": {"hosts": {"buckets": [
+				{"key": "web-2", "doc_count": 40},
+				{"key": "web-1", "doc_count": 100}
+			]}}
+		}
+	}`, &resp)
+
+	cases := []struct {
+		condition string
+		met       bool
+	}{
+		{`{"field": "hits.total.value", "changed": true}`, false},
+		{`{"field": "hits.total.value", "changed": false}`, true},
+		{`{"field": "previous.hits.total.value", "eq": 100}`, true},
+		{`{"field": "aggregations.hosts.buckets.doc_count", "increased_by_percent": 50, "quantifier": "any"}`, true},
+		{`{"field": "aggregations.hosts.buckets.doc_count", "increased_by_percent": 70, "quantifier": "any"}`, false},
+		{`{"field": "aggregations.hosts.buckets.doc_count", "decreased_by_percent": 40, "exactly": 1}`, true},
+		{`{"field": "aggregations.hosts.buckets.doc_count", "decreased_by_percent": 50, "quantifier": "any"}`, false},
+		{`{"field": "aggregations.hosts.buckets.doc_count", "changed": true, "quantifier": "all"}`, true},
+		{`{"expr": "aggregations.hosts.buckets[0].doc_count > previous.aggregations.hosts.buckets[1].doc_count"}`, true},
+	}
+	for _, c := range cases {
+		var condition Condition
+		decodeJSON(t, c.condition, &condition)
+		if err := condition.validate(); err != nil {
+			t.Fatalf("condition %s should be valid: %v", c.condition, err)
+		}
+		if met := ConditionsMet(resp, []Condition{condition}); met != c.met {
+			t.Errorf("expected condition %s to evaluate to %t", c.condition, c.met)
+		}
+	}
+
+	// Without a previous run nothing has changed, increased or decreased
+	delete(resp, PreviousKey)
+	for _, data := range []string{
+		`{"field": "hits.total.value", "changed": true}`,
+		`{"field": "hits.total.value", "changed": false}`,
+		`{"field": "hits.total.value", "increased_by_percent": 0}`,
+	} {
+		var condition Condition
+		decodeJSON(t, data, &condition)
+		if err := condition.validate(); err != nil {
+			t.Fatalf("condition %s should be valid: %v", data, err)
+		}
+		if ConditionsMet(resp, []Condition{condition}) {
+			t.Errorf("expected condition %s not to be met without a previous run", data)
+		}
+	}
+
+	var condition Condition
+	decodeJSON(t, `{"field": "hits.total.value", "changed": true}`, &condition)
+	if err := condition.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, traces := ExplainConditions(resp, []Condition{condition}); traces[0].Error == "" {
+		t.Error("expected the trace to tell there is no previous run")
+	}
+
+	invalid := []string{
+		`{"field": "doc_count", "changed": "yes"}`,
+		`{"field": "doc_count", "increased_by_percent": -10}`,
+		`{"field": "doc_count", "decreased_by_percent": "half"}`,
+	}
+	for _, data := range invalid {
+		var condition Condition
+		decodeJSON(t, data, &condition)
+		if err := condition.validate(); err == nil {
+			t.Errorf("expected condition %s to be invalid", data)
+		}
+	}
+}
+
+func TestConditionTypes(t *testing.T) {
+	var resp map[string]interface{}
+	decodeJSON(t, `{
+		"hits": {"hits": [{"_source": {"enabled": true, "status": "503", "bytes": 9007199254740993}}]},
+		"aggregations": {"latency": {"value": null}}
+	}`, &resp)
+
+	cases := []struct {
+		condition string
+		met       bool
+	}{
+		{`{"field": "hits.hits._source.enabled", "eq": "true"}`, false},
+		{`{"field": "hits.hits._source.enabled", "eq": true}`, true},
+		{`{"field": "hits.hits._source.enabled", "ne": true}`, false},
+		{`{"field": "hits.hits._source.status", "gt": 500}`, false},
+		{`{"field": "hits.hits._source.status", "eq": "503"}`, true},
+		{`{"field": "hits.hits._source.bytes", "eq": 9007199254740993}`, true},
+		{`{"field": "hits.hits._source.bytes", "eq": 9007199254740992}`, false},
+		{`{"field": "aggregations.latency.value", "lt": 100}`, false},
+		{`{"field": "aggregations.latency.value", "ge": 100}`, false},
+		{`{"field": "aggregations.latency.value", "missing": true}`, true},
+	}
+	for _, c := range cases {
+		var condition Condition
+		decodeJSON(t, c.condition, &condition)
+		if err := condition.validate(); err != nil {
+			t.Fatalf("condition %s should be valid: %v", c.condition, err)
+		}
+		if met := ConditionsMet(resp, []Condition{condition}); met != c.met {
+			t.Errorf("expected condition %s to evaluate to %t", c.condition, c.met)
+		}
+	}
+
+	invalid := []string{
+		`{"field": "status", "gtt": 500}`,
+		`{"field": "status", "gt": "500"}`,
+		`{"field": "status", "eq": ["503"]}`,
+		`{"field": "status", "eq": 1e999999999999}`,
+	}
+	for _, data := range invalid {
+		var condition Condition
+		decodeJSON(t, data, &condition)
+		if err := condition.validate(); err == nil {
+			t.Errorf("expected condition %s to be invalid", data)
+		}
+	}
+
+	// Values not decoded as json.Numbers satisfy no check
+	took, err := ParseCondition(map[string]interface{}{"field": "took", "lt": json.Number("100")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ConditionsMet(map[string]interface{}{"took": float64(5)}, []Condition{took}) {
+		t.Error("expected a float64 value not to satisfy the condition")
+	}
+
+	var unvalidated Condition
+	decodeJSON(t, `{"field": "hits.hits._source.enabled", "eq": true}`, &unvalidated)
+	if ConditionsMet(resp, []Condition{unvalidated}) {
+		t.Fatal("conditions that were not validated should not be met")
+	}
+}
+
+func TestExplainConditions(t *testing.T) {
+	var resp map[string]interface{}
+	decodeJSON(t, conditionsResponse, &resp)
+
+	var conditions []Condition
+	decodeJSON(t, `[
+		{"field": "hits.hits._source.status", "quantifier": "all", "ge": 500, "lt": 600},
+		{"any_of": [{"field": "hits.hits._source.user", "exists": true}, {"expr": "hits.hits[0]._source.status == 503"}]}
+	]`, &conditions)
+	for i := range conditions {
+		if err := conditions[i].validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	met, traces := ExplainConditions(resp, conditions)
+	if met || ConditionsMet(resp, conditions) {
+		t.Fatal("expected the conditions not to be met")
+	}
+	if len(traces) != 2 {
+		t.Fatalf("expected a trace per condition, got %d", len(traces))
+	}
+
+	field := traces[0]
+	if field.Met || field.Field != "hits.hits._source.status" || field.Quantifier != "all" || len(field.Values) != 2 {
+		t.Fatalf("unexpected trace %+v", field)
+	}
+	second := field.Values[1]
+	if second.Path != "hits.hits[1]._source.status" || second.Satisfied {
+		t.Fatalf("unexpected value trace %+v", second)
+	}
+	if len(second.Operators) != 2 || second.Operators[0].Operator != "lt" || !second.Operators[0].Met || second.Operators[1].Met {
+		t.Fatalf("expected every operator to be traced, got %+v", second.Operators)
+	}
+
+	group := traces[1]
+	if !group.Met || group.Group != "any_of" || len(group.Members) != 2 {
+		t.Fatalf("unexpected group trace %+v", group)
+	}
+	if group.Members[0].Met || group.Members[0].Error == "" || !group.Members[1].Met {
+		t.Fatalf("unexpected member traces %+v, %+v", group.Members[0], group.Members[1])
+	}
+}
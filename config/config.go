@@ -4,23 +4,211 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/lbzss/elasticsearch-alert/utils"
 	homedir "github.com/mitchellh/go-homedir"
+	"github.com/robfig/cron"
 )
 
 const (
 	envConfigFile     string = "GO_ELASTICSEARCH_ALERTS_CONFIG_FILE"
 	envRulesDir       string = "GO_ELASTICSEARCH_ALERTS_RULES_DIR"
+	envSilencesFile   string = "GO_ELASTICSEARCH_ALERTS_SILENCES_FILE"
+	envAcksFile       string = "GO_ELASTICSEARCH_ALERTS_ACKS_FILE"
 	defaultConfigFile string = "/etc/go-elasticsearch-alerts/config.json"
 	defaultRulesDir   string = "/etc/go-elasticsearch-alerts/rules"
+	defaultSilences   string = "/var/lib/go-elasticsearch-alerts/silences.json"
+	defaultAcks       string = "/var/lib/go-elasticsearch-alerts/acks.json"
 )
 
 type Config struct {
 	Elasticsearch *ESConfig    `json:"elasticsearch"`
 	Rules         []RuleConfig `json:"-"`
+
+	// Receivers are named sets of outputs shared by all rules. Route
+	// decides which of them get the alerts of rules without outputs
+	Receivers []ReceiverConfig `json:"receivers"`
+	Route     *RouteConfig     `json:"route"`
+
+	// InhibitRules suppress alerts while related alerts are firing
+	InhibitRules []InhibitRuleConfig `json:"inhibit_rules"`
+
+	// HTTP configures the HTTP API, e.g. for acknowledging alerts
+	HTTP *HTTPConfig `json:"http"`
+
+	// TemplatesDir is a directory of '*.tmpl' files shared by the message
+	// templates of all rules and outputs
+	TemplatesDir string `json:"templates_dir"`
+
+	// Kibana, if set, is used to link alerts to Kibana Discover
+	Kibana *KibanaConfig `json:"kibana"`
+}
+
+type KibanaConfig struct {
+	// URL is the base URL of Kibana, including the space if any, e.g.
+	// 'https://kibana.example.com/s/ops'
+	URL string `json:"url"`
+
+	// DataViewID is the ID of the data view (index pattern) Discover
+	// links use unless a rule specifies its own
+	DataViewID string `json:"data_view_id"`
+}
+
+func (k *KibanaConfig) validate() error {
+	if k.URL == "" {
+		return errors.New("no 'kibana.url' field found")
+	}
+	return nil
+}
+
+type HTTPConfig struct {
+	// Address is the address the HTTP API listens on, e.g.
+	// '127.0.0.1:9100'
+	Address string `json:"address"`
+
+	// Token must be sent by clients as a bearer token. It is required
+	// unless the API only listens on a loopback address
+	Token string `json:"token"`
+}
+
+func (h *HTTPConfig) validate() error {
+	if h.Address == "" {
+		return errors.New("no 'http.address' field found")
+	}
+
+	host, _, err := net.SplitHostPort(h.Address)
+	if err != nil {
+		return fmt.Errorf("invalid 'http.address': %v", err)
+	}
+	if h.Token != "" {
+		return nil
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return errors.New("'http.token' is required unless 'http.address' is a loopback address, e.g. '127.0.0.1:9100'")
+	}
+	return nil
+}
+
+func (c *Config) validateRouting() error {
+	receivers := make(map[string]struct{}, len(c.Receivers))
+	for i, receiver := range c.Receivers {
+		if err := receiver.validate(); err != nil {
+			return fmt.Errorf("error in receiver %d: %v", i+1, err)
+		}
+		if _, ok := receivers[receiver.Name]; ok {
+			return fmt.Errorf("duplicate receiver %s", receiver.Name)
+		}
+		receivers[receiver.Name] = struct{}{}
+	}
+
+	if c.Route == nil {
+		return nil
+	}
+
+	if c.Route.Receiver == "" {
+		return errors.New("the root route must have a receiver ('route.receiver')")
+	}
+	return c.Route.validate(receivers)
+}
+
+// InhibitRuleConfig suppresses alerts matching all of TargetMatchers while
+// an alert of another rule matching all of SourceMatchers is firing and
+// both have the same values for the labels in Equal. Matchers have the
+// same form as those of routes.
+type InhibitRuleConfig struct {
+	SourceMatchers []string `json:"source_matchers"`
+	TargetMatchers []string `json:"target_matchers"`
+	Equal          []string `json:"equal"`
+}
+
+func (i *InhibitRuleConfig) validate() error {
+	if len(i.SourceMatchers) < 1 {
+		return errors.New("at least one source matcher must be specified ('source_matchers')")
+	}
+
+	if len(i.TargetMatchers) < 1 {
+		return errors.New("at least one target matcher must be specified ('target_matchers')")
+	}
+
+	if err := validateMatchers(i.SourceMatchers); err != nil {
+		return fmt.Errorf("error in source matchers: %v", err)
+	}
+	if err := validateMatchers(i.TargetMatchers); err != nil {
+		return fmt.Errorf("error in target matchers: %v", err)
+	}
+	return nil
+}
+
+type ReceiverConfig struct {
+	Name    string         `json:"name"`
+	Outputs []OutputConfig `json:"outputs"`
+}
+
+func (r *ReceiverConfig) validate() error {
+	if r.Name == "" {
+		return errors.New("no 'name' field found")
+	}
+
+	if len(r.Outputs) < 1 {
+		return fmt.Errorf("at least one output must be specified for receiver %s", r.Name)
+	}
+
+	for i, output := range r.Outputs {
+		if err := output.validate(); err != nil {
+			return fmt.Errorf("error in output %d of receiver %s: %v", i+1, r.Name, err)
+		}
+	}
+	return nil
+}
+
+// RouteConfig is a node of the routing tree. Matchers have the form
+// 'name=value', 'name!=value', 'name=~regex' or 'name!~regex' and match
+// against rule labels or the properties 'rule', 'key', 'filter', 'state'
+// and 'severity' of an alert.
+type RouteConfig struct {
+	Receiver string         `json:"receiver"`
+	Matchers []string       `json:"matchers"`
+	Continue bool           `json:"continue"`
+	Routes   []*RouteConfig `json:"routes"`
+}
+
+func (r *RouteConfig) validate(receivers map[string]struct{}) error {
+	if r.Receiver != "" {
+		if _, ok := receivers[r.Receiver]; !ok {
+			return fmt.Errorf("route refers to unknown receiver %s", r.Receiver)
+		}
+	}
+
+	if err := validateMatchers(r.Matchers); err != nil {
+		return fmt.Errorf("error in route matchers: %v", err)
+	}
+
+	for _, route := range r.Routes {
+		if len(route.Matchers) < 1 {
+			return errors.New("all child routes must have at least one matcher ('route.matchers')")
+		}
+		if err := route.validate(receivers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateMatchers checks that the matchers can be parsed so that a
+// configuration the daemon cannot start with is rejected up front.
+func validateMatchers(matchers []string) error {
+	for _, s := range matchers {
+		if _, err := utils.ParseMatcher(s); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type ESConfig struct {
@@ -60,7 +248,20 @@ type RuleConfig struct {
 	Filters              []string               `json:"filters"`
 	Outputs              []OutputConfig         `json:"outputs"`
 	Conditions           []Condition            `json:"conditions"`
-	// BodyField string `json:"body_field"`
+	Labels               map[string]string      `json:"labels"`
+	Annotations          map[string]string      `json:"annotations"`
+	Severity             string                 `json:"severity"`
+	Realert              *RealertConfig         `json:"realert"`
+	Dedup                *DedupConfig           `json:"dedup"`
+	For                  string                 `json:"for"`
+	ForDuration          time.Duration          `json:"-"`
+	SendResolved         bool                   `json:"send_resolved"`
+	Escalation           []EscalationStepConfig `json:"escalation"`
+	Template             *TemplateConfig        `json:"template"`
+	KibanaDataViewID     string                 `json:"kibana_data_view_id"`
+	BodyField            string                 `json:"body_field"`
+	Attachment           *AttachmentConfig      `json:"attachment"`
+	Debug                bool                   `json:"debug"`
 }
 
 func (r *RuleConfig) validate() error {
@@ -79,12 +280,14 @@ func (r *RuleConfig) validate() error {
 		r.Filters = []string{}
 	}
 
-	if r.Outputs == nil {
-		return errors.New("no 'output' field found")
+	for i, filter := range r.Filters {
+		if err := utils.ValidatePath(filter); err != nil {
+			return fmt.Errorf("error in filter %d of rule %s: %v", i+1, r.Name, err)
+		}
 	}
 
-	if len(r.Outputs) < 1 {
-		return errors.New("at least one output must be specified ('outputs')")
+	if err := utils.ValidatePath(r.BodyField); err != nil {
+		return fmt.Errorf("error in 'body_field' field of rule %s: %v", r.Name, err)
 	}
 
 	for i, output := range r.Outputs {
@@ -93,17 +296,224 @@ func (r *RuleConfig) validate() error {
 		}
 	}
 
-	for i, condition := range r.Conditions {
-		if err := condition.validate(); err != nil {
+	for i := range r.Conditions {
+		if err := r.Conditions[i].validate(); err != nil {
 			return fmt.Errorf("error in condition %d of rule %s: %v", i+1, r.Name, err)
 		}
 	}
+
+	if r.Realert != nil {
+		if err := r.Realert.validate(); err != nil {
+			return fmt.Errorf("error in 'realert' field of rule %s: %v", r.Name, err)
+		}
+	}
+
+	if r.Dedup != nil {
+		if err := r.Dedup.validate(); err != nil {
+			return fmt.Errorf("error in 'dedup' field of rule %s: %v", r.Name, err)
+		}
+	}
+
+	switch r.Severity {
+	case "", "critical", "error", "warning", "info":
+	default:
+		return fmt.Errorf("field 'severity' of rule %s must be one of 'critical', 'error', 'warning' or 'info'", r.Name)
+	}
+
+	if r.Template != nil {
+		if err := r.Template.validate(); err != nil {
+			return fmt.Errorf("error in 'template' field of rule %s: %v", r.Name, err)
+		}
+	}
+
+	for i, step := range r.Escalation {
+		if err := step.validate(); err != nil {
+			return fmt.Errorf("error in escalation step %d of rule %s: %v", i+1, r.Name, err)
+		}
+		if i > 0 && step.Duration < r.Escalation[i-1].Duration {
+			return fmt.Errorf("escalation steps of rule %s must be ordered by 'after'", r.Name)
+		}
+		r.Escalation[i] = step
+	}
+
+	if r.Attachment != nil {
+		if r.BodyField == "" {
+			return fmt.Errorf("field 'attachment' of rule %s requires a 'body_field'", r.Name)
+		}
+		if err := r.Attachment.validate(); err != nil {
+			return fmt.Errorf("error in 'attachment' field of rule %s: %v", r.Name, err)
+		}
+		for i, output := range r.Outputs {
+			if err := output.validateAttachments(); err != nil {
+				return fmt.Errorf("error in output %d of rule %s: %v", i+1, r.Name, err)
+			}
+		}
+		for i, step := range r.Escalation {
+			for j, output := range step.Outputs {
+				if err := output.validateAttachments(); err != nil {
+					return fmt.Errorf("error in output %d of escalation step %d of rule %s: %v", j+1, i+1, r.Name, err)
+				}
+			}
+		}
+	}
+
+	if r.For != "" {
+		d, err := time.ParseDuration(r.For)
+		if err != nil {
+			return fmt.Errorf("error parsing 'for' field of rule %s: %v", r.Name, err)
+		}
+		if d < 0 {
+			return fmt.Errorf("field 'for' of rule %s must not be negative", r.Name)
+		}
+		r.ForDuration = d
+	}
+	return nil
+}
+
+// RealertConfig throttles repeat alerts. Once an alert has been sent,
+// further alerts are suppressed until Interval has passed. If Key is
+// set, it is resolved against the search response and every distinct
+// value found (e.g. a bucket key or 'host.name') is throttled on its own,
+// and alerts for bucket keys only report the buckets that got through.
+// Keys that resolve, or all of them if the rule stops firing, are let
+// through again right away.
+type RealertConfig struct {
+	Interval string        `json:"interval"`
+	Key      string        `json:"key"`
+	Duration time.Duration `json:"-"`
+}
+
+func (r *RealertConfig) validate() error {
+	if r.Interval == "" {
+		return errors.New("no 'interval' field found")
+	}
+
+	d, err := time.ParseDuration(r.Interval)
+	if err != nil {
+		return fmt.Errorf("error parsing 'interval': %v", err)
+	}
+
+	if d <= 0 {
+		return errors.New("field 'interval' must be a positive duration")
+	}
+	r.Duration = d
+
+	if err := utils.ValidatePath(r.Key); err != nil {
+		return fmt.Errorf("error in 'key': %v", err)
+	}
+	return nil
+}
+
+// AttachmentConfig renders the hits found at a rule's 'body_field' into a
+// file attached to its alerts, keeping the alert text short. Format is
+// 'csv' or 'ndjson'. CSV files have one column per entry of Columns,
+// which are paths into the '_source' of the hits or, if they start with
+// an underscore, fields of the hits themselves such as '_id'.
+type AttachmentConfig struct {
+	Format   string   `json:"format"`
+	Columns  []string `json:"columns"`
+	Filename string   `json:"filename"`
+}
+
+func (a *AttachmentConfig) validate() error {
+	switch a.Format {
+	case "csv":
+		if len(a.Columns) < 1 {
+			return errors.New("at least one column must be specified ('columns') for CSV attachments")
+		}
+	case "ndjson":
+		if len(a.Columns) > 0 {
+			return errors.New("field 'columns' is only supported for CSV attachments")
+		}
+	default:
+		return errors.New("field 'format' must be one of 'csv' or 'ndjson'")
+	}
+
+	if strings.ContainsAny(a.Filename, `/\`) {
+		return errors.New("field 'filename' must not contain a path separator")
+	}
+	return nil
+}
+
+// EscalationStepConfig notifies Outputs once a rule has been firing for
+// After without being acknowledged, whichever of its keys fire.
+type EscalationStepConfig struct {
+	After    string         `json:"after"`
+	Outputs  []OutputConfig `json:"outputs"`
+	Duration time.Duration  `json:"-"`
+}
+
+func (e *EscalationStepConfig) validate() error {
+	if e.After != "" {
+		d, err := time.ParseDuration(e.After)
+		if err != nil {
+			return fmt.Errorf("error parsing 'after': %v", err)
+		}
+		if d < 0 {
+			return errors.New("field 'after' must not be negative")
+		}
+		e.Duration = d
+	}
+
+	if len(e.Outputs) < 1 {
+		return errors.New("at least one output must be specified ('outputs')")
+	}
+
+	for i, output := range e.Outputs {
+		if err := output.validate(); err != nil {
+			return fmt.Errorf("error in output %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// DedupConfig makes an alert identical to one already sent within Window
+// be dropped before it is written to any output. Alerts are identical
+// if they have the same fingerprint, which is computed from the rule name
+// and the keys of the records and, if IncludeHits is true, the '_id's of
+// the matched hits. Alerts firing again after the rule resolved are
+// never dropped.
+type DedupConfig struct {
+	Window      string        `json:"window"`
+	IncludeHits bool          `json:"include_hits"`
+	Duration    time.Duration `json:"-"`
+}
+
+func (d *DedupConfig) validate() error {
+	if d.Window == "" {
+		return errors.New("no 'window' field found")
+	}
+
+	v, err := time.ParseDuration(d.Window)
+	if err != nil {
+		return fmt.Errorf("error parsing 'window': %v", err)
+	}
+
+	if v <= 0 {
+		return errors.New("field 'window' must be a positive duration")
+	}
+	d.Duration = v
 	return nil
 }
 
 type OutputConfig struct {
-	Type   string                 `json:"type"`
-	Config map[string]interface{} `json:"config"`
+	Type     string                 `json:"type"`
+	Config   map[string]interface{} `json:"config"`
+	Realert  *RealertConfig         `json:"realert"`
+	Digest   *DigestConfig          `json:"digest"`
+	Template *TemplateConfig        `json:"template"`
+}
+
+// validateAttachments returns an error if the output is unable to write
+// the attachments of alerts.
+func (o *OutputConfig) validateAttachments() error {
+	if o.Type != "file" {
+		return nil
+	}
+	if dir, _ := o.Config["attachments_dir"].(string); dir == "" {
+		return errors.New("file outputs of rules with an 'attachment' must set 'attachments_dir'")
+	}
+	return nil
 }
 
 func (o *OutputConfig) validate() error {
@@ -114,6 +524,84 @@ func (o *OutputConfig) validate() error {
 	if o.Config == nil || len(o.Config) < 1 {
 		return errors.New("all outputs must have a config field ('output.config')")
 	}
+
+	if o.Realert != nil {
+		if err := o.Realert.validate(); err != nil {
+			return fmt.Errorf("error in 'output.realert' field: %v", err)
+		}
+		if o.Realert.Key != "" {
+			return errors.New("field 'output.realert.key' is not supported, outputs are throttled per rule")
+		}
+	}
+
+	if o.Digest != nil {
+		if err := o.Digest.validate(); err != nil {
+			return fmt.Errorf("error in 'output.digest' field: %v", err)
+		}
+	}
+
+	if o.Template != nil {
+		if err := o.Template.validate(); err != nil {
+			return fmt.Errorf("error in 'output.template' field: %v", err)
+		}
+	}
+	return nil
+}
+
+// DigestConfig batches the alerts written to an output and sends them as
+// one summary, either every Window or on the cron Schedule.
+type DigestConfig struct {
+	Window   string        `json:"window"`
+	Schedule string        `json:"schedule"`
+	Cron     cron.Schedule `json:"-"`
+}
+
+func (d *DigestConfig) validate() error {
+	switch {
+	case d.Window != "" && d.Schedule != "":
+		return errors.New("only one of 'window' and 'schedule' may be specified")
+	case d.Window != "":
+		v, err := time.ParseDuration(d.Window)
+		if err != nil {
+			return fmt.Errorf("error parsing 'window': %v", err)
+		}
+		if v < time.Second {
+			return errors.New("field 'window' must be at least one second")
+		}
+		d.Cron = cron.Every(v)
+	case d.Schedule != "":
+		schedule, err := cron.Parse(d.Schedule)
+		if err != nil {
+			return fmt.Errorf("error parsing 'schedule': %v", err)
+		}
+		d.Cron = schedule
+	default:
+		return errors.New("one of 'window' and 'schedule' must be specified")
+	}
+	return nil
+}
+
+// TemplateConfig is a Go template rendering the message of an alert,
+// given either inline as Text or by the Name of a file in the templates
+// directory. Format is 'text' (default) or 'html'.
+type TemplateConfig struct {
+	Text   string `json:"text"`
+	Name   string `json:"name"`
+	Format string `json:"format"`
+}
+
+func (t *TemplateConfig) validate() error {
+	if t.Text == "" && t.Name == "" {
+		return errors.New("one of 'text' and 'name' must be specified")
+	}
+
+	if t.Text != "" && t.Name != "" {
+		return errors.New("only one of 'text' and 'name' may be specified")
+	}
+
+	if t.Format != "" && t.Format != "text" && t.Format != "html" {
+		return errors.New("field 'format' must either be 'text' or 'html'")
+	}
 	return nil
 }
 
@@ -0,0 +1,71 @@
+package config
+
+import "testing"
+
+func TestHTTPConfig(t *testing.T) {
+	cases := []struct {
+		config HTTPConfig
+		valid  bool
+	}{
+		{HTTPConfig{Address: "127.0.0.1:9100"}, true},
+		{HTTPConfig{Address: "localhost:9100"}, true},
+		{HTTPConfig{Address: "[::1]:9100"}, true},
+		{HTTPConfig{Address: ":9100"}, false},
+		{HTTPConfig{Address: "0.0.0.0:9100"}, false},
+		{HTTPConfig{Address: ":9100", Token: "secret"}, true},
+		{HTTPConfig{Address: "9100", Token: "secret"}, false},
+		{HTTPConfig{}, false},
+	}
+	for _, c := range cases {
+		if err := c.config.validate(); (err == nil) != c.valid {
+			t.Errorf("expected %+v to be valid: %t, got error %v", c.config, c.valid, err)
+		}
+	}
+}
+
+func TestRuleAttachmentOutputs(t *testing.T) {
+	rule := func(outputs ...OutputConfig) *RuleConfig {
+		return &RuleConfig{
+			Name:               "rule",
+			ElasticsearchIndex: "logs-*",
+			CronSchedule:       "@every 1m",
+			BodyField:          "hits.hits",
+			Attachment:         &AttachmentConfig{Format: "ndjson"},
+			Outputs:            outputs,
+		}
+	}
+	file := OutputConfig{Type: "file", Config: map[string]interface{}{"file": "alerts.log"}}
+	withDir := OutputConfig{Type: "file", Config: map[string]interface{}{"file": "alerts.log", "attachments_dir": "attachments"}}
+
+	if err := rule(withDir).validate(); err != nil {
+		t.Fatalf("expected file outputs with an attachments dir to be valid: %v", err)
+	}
+	if err := rule(file).validate(); err == nil {
+		t.Fatal("expected file outputs without an attachments dir to be invalid for rules with attachments")
+	}
+}
+
+func TestRouteMatchers(t *testing.T) {
+	receivers := map[string]struct{}{"ops": {}}
+	route := func(matchers ...string) *RouteConfig {
+		return &RouteConfig{
+			Receiver: "ops",
+			Routes:   []*RouteConfig{{Receiver: "ops", Matchers: matchers}},
+		}
+	}
+
+	if err := route("severity=critical", "rule=~disk-.*").validate(receivers); err != nil {
+		t.Fatalf("expected valid matchers to be accepted: %v", err)
+	}
+	if err := route("severity").validate(receivers); err == nil {
+		t.Fatal("expected matchers without an operator to be rejected")
+	}
+	if err := route("rule=~(").validate(receivers); err == nil {
+		t.Fatal("expected matchers with an invalid regular expression to be rejected")
+	}
+
+	inhibit := InhibitRuleConfig{SourceMatchers: []string{"severity=critical"}, TargetMatchers: []string{"=warning"}}
+	if err := inhibit.validate(); err == nil {
+		t.Fatal("expected inhibit rules with invalid matchers to be rejected")
+	}
+}
@@ -0,0 +1,219 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	timeFormatEpochMillis = "epoch_millis"
+	timeFormatEpochSecond = "epoch_second"
+)
+
+// timeNow is the clock relative times are evaluated against
+var timeNow = time.Now
+
+// timeLayouts are tried in order to parse dates if a condition specifies
+// no 'time_format'
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// dateMath is an Elasticsearch date math expression, e.g. 'now-1h' or
+// '2023-10-19T00:00:00Z||+1d/d': an anchor followed by additions or
+// subtractions of whole time units and optionally rounded down to a unit.
+// Like Elasticsearch without a 'time_zone', it is evaluated in UTC.
+type dateMath struct {
+	anchor time.Time // zero for 'now'
+	ops    []dateMathOp
+	round  byte
+}
+
+type dateMathOp struct {
+	n    int
+	unit byte
+}
+
+// parseDateMath parses an expression whose anchor, if it is a date, is in
+// the given format, see parseTime.
+func parseDateMath(s, format string) (*dateMath, error) {
+	d := new(dateMath)
+	var rest string
+	switch {
+	case strings.HasPrefix(s, "now"):
+		rest = s[len("now"):]
+	case strings.Contains(s, "||"):
+		i := strings.Index(s, "||")
+		anchor, err := parseTime(s[:i], format)
+		if err != nil {
+			return nil, err
+		}
+		d.anchor, rest = anchor, s[i+2:]
+	default:
+		anchor, err := parseTime(s, format)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither a date nor date math starting with 'now'", s)
+		}
+		d.anchor = anchor
+		return d, nil
+	}
+
+	for rest != "" {
+		switch rest[0] {
+		case '+', '-':
+			i := 1
+			for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+				i++
+			}
+			if i == 1 || i == len(rest) {
+				return nil, fmt.Errorf("invalid date math %q: expected a number and a unit after '%c'", s, rest[0])
+			}
+			n, err := strconv.Atoi(rest[1:i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid date math %q: %v", s, err)
+			}
+			if rest[0] == '-' {
+				n = -n
+			}
+			if !isDateMathUnit(rest[i]) {
+				return nil, fmt.Errorf("invalid date math %q: unknown unit '%c'", s, rest[i])
+			}
+			d.ops = append(d.ops, dateMathOp{n: n, unit: rest[i]})
+			rest = rest[i+1:]
+		case '/':
+			if len(rest) != 2 || !isDateMathUnit(rest[1]) {
+				return nil, fmt.Errorf("invalid date math %q: rounding must be a single unit at the end", s)
+			}
+			d.round = rest[1]
+			rest = ""
+		default:
+			return nil, fmt.Errorf("invalid date math %q: unexpected %q", s, rest)
+		}
+	}
+	return d, nil
+}
+
+func isDateMathUnit(u byte) bool {
+	return strings.IndexByte("yMwdhHms", u) >= 0
+}
+
+// at evaluates the expression with now as the current time.
+func (d *dateMath) at(now time.Time) time.Time {
+	t := d.anchor
+	if t.IsZero() {
+		t = now
+	}
+	t = t.UTC()
+
+	for _, op := range d.ops {
+		switch op.unit {
+		case 'y':
+			t = t.AddDate(op.n, 0, 0)
+		case 'M':
+			t = t.AddDate(0, op.n, 0)
+		case 'w':
+			t = t.AddDate(0, 0, 7*op.n)
+		case 'd':
+			t = t.AddDate(0, 0, op.n)
+		case 'h', 'H':
+			t = t.Add(time.Duration(op.n) * time.Hour)
+		case 'm':
+			t = t.Add(time.Duration(op.n) * time.Minute)
+		case 's':
+			t = t.Add(time.Duration(op.n) * time.Second)
+		}
+	}
+
+	y, mo, day := t.Date()
+	switch d.round {
+	case 'y':
+		t = time.Date(y, 1, 1, 0, 0, 0, 0, t.Location())
+	case 'M':
+		t = time.Date(y, mo, 1, 0, 0, 0, 0, t.Location())
+	case 'w':
+		offset := (int(t.Weekday()) + 6) % 7 // weeks start on Monday
+		t = time.Date(y, mo, day-offset, 0, 0, 0, 0, t.Location())
+	case 'd':
+		t = time.Date(y, mo, day, 0, 0, 0, 0, t.Location())
+	case 'h', 'H':
+		t = t.Truncate(time.Hour)
+	case 'm':
+		t = t.Truncate(time.Minute)
+	case 's':
+		t = t.Truncate(time.Second)
+	}
+	return t
+}
+
+// parseInterval parses a Go duration, e.g. '1h30m', or a number of
+// Elasticsearch time units, e.g. '2d' or '1w'.
+func parseInterval(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+
+	if len(s) < 2 {
+		return 0, fmt.Errorf("invalid interval %q", s)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval %q", s)
+	}
+	switch s[len(s)-1] {
+	case 'd':
+		return time.Duration(n) * 24 * time.Hour, nil
+	case 'w':
+		return time.Duration(n) * 7 * 24 * time.Hour, nil
+	}
+	return 0, fmt.Errorf("invalid interval %q", s)
+}
+
+// parseTime parses a date in the given format, which is 'epoch_millis',
+// 'epoch_second' or a Go time layout. If format is empty, the layouts in
+// timeLayouts are tried in turn.
+func parseTime(s, format string) (time.Time, error) {
+	switch format {
+	case timeFormatEpochMillis, timeFormatEpochSecond:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s date %q", format, s)
+		}
+		return epochTime(n, format), nil
+	case "":
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("unrecognized date %q, set 'time_format'", s)
+	default:
+		return time.Parse(format, s)
+	}
+}
+
+func epochTime(n float64, format string) time.Time {
+	if format == timeFormatEpochSecond {
+		return time.Unix(0, int64(n*float64(time.Second)))
+	}
+	return time.Unix(0, int64(n*float64(time.Millisecond)))
+}
+
+// validateTimeFormat returns an error if format cannot be used to parse
+// dates, i.e. it is a layout that doesn't format any part of a date.
+func validateTimeFormat(format string) error {
+	switch format {
+	case timeFormatEpochMillis, timeFormatEpochSecond:
+		return nil
+	}
+	if time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC).Format(format) == format {
+		return errors.New("time format must be 'epoch_millis', 'epoch_second' or a Go time layout, e.g. '2006-01-02T15:04:05Z07:00'")
+	}
+	return nil
+}
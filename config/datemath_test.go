@@ -0,0 +1,103 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDateMath(t *testing.T) {
+	now := time.Date(2023, 10, 19, 14, 35, 20, 0, time.UTC)
+	cases := map[string]time.Time{
+		"now":                           now,
+		"now-1h":                        now.Add(-time.Hour),
+		"now-1d/d":                      time.Date(2023, 10, 18, 0, 0, 0, 0, time.UTC),
+		"now+2M-1w":                     time.Date(2023, 12, 12, 14, 35, 20, 0, time.UTC),
+		"now/w":                         time.Date(2023, 10, 16, 0, 0, 0, 0, time.UTC),
+		"2023-01-01T00:00:00Z||+1y/M":   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		"2023-05-06":                    time.Date(2023, 5, 6, 0, 0, 0, 0, time.UTC),
+		"2023-05-06T07:08:09.5+02:00||": time.Date(2023, 5, 6, 5, 8, 9, 500000000, time.UTC),
+	}
+	for expr, expected := range cases {
+		d, err := parseDateMath(expr, "")
+		if err != nil {
+			t.Errorf("error parsing %q: %v", expr, err)
+			continue
+		}
+		if got := d.at(now); !got.Equal(expected) {
+			t.Errorf("expected %q to be %v, got %v", expr, expected, got)
+		}
+	}
+
+	// Rounding happens in UTC whatever the zone of the clock
+	local := time.Date(2023, 10, 19, 1, 30, 0, 0, time.FixedZone("UTC+5", 5*60*60))
+	for expr, expected := range map[string]time.Time{
+		"now/d":                         time.Date(2023, 10, 18, 0, 0, 0, 0, time.UTC),
+		"now/h":                         time.Date(2023, 10, 18, 20, 0, 0, 0, time.UTC),
+		"2023-10-19T01:30:00+05:00||/d": time.Date(2023, 10, 18, 0, 0, 0, 0, time.UTC),
+	} {
+		d, err := parseDateMath(expr, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.at(local); !got.Equal(expected) {
+			t.Errorf("expected %q to be %v on a UTC+5 clock, got %v", expr, expected, got)
+		}
+	}
+
+	for _, expr := range []string{"now-", "now-1x", "now/d+1h", "yesterday", "now-h"} {
+		if _, err := parseDateMath(expr, ""); err == nil {
+			t.Errorf("expected %q to be invalid", expr)
+		}
+	}
+}
+
+func TestConditionTimeOperators(t *testing.T) {
+	timeNow = func() time.Time { return time.Date(2023, 10, 19, 14, 35, 0, 0, time.UTC) }
+	defer func() { timeNow = time.Now }()
+
+	var resp map[string]interface{}
+	decodeJSON(t, `{
+		"hits": {"hits": [{"_source": {"@timestamp": "2023-10-19T14:10:00Z", "ingested": "19/10/2023 14:30"}}]},
+		"aggregations": {"last_seen": {"value": 1697724000000, "value_as_string": "2023-10-19T14:00:00.000Z"}}
+	}`, &resp)
+
+	cases := []struct {
+		condition string
+		met       bool
+	}{
+		{`{"field": "aggregations.last_seen.value_as_string", "older_than": "15m"}`, true},
+		{`{"field": "aggregations.last_seen.value_as_string", "older_than": "1h"}`, false},
+		{`{"field": "aggregations.last_seen.value", "newer_than": "1d"}`, true},
+		{`{"field": "hits.hits._source.@timestamp", "before": "now-20m"}`, true},
+		{`{"field": "hits.hits._source.@timestamp", "after": "now/h"}`, true},
+		{`{"field": "hits.hits._source.@timestamp", "after": "now-10m"}`, false},
+		{`{"field": "hits.hits._source.ingested", "newer_than": "10m", "time_format": "02/01/2006 15:04"}`, true},
+		{`{"field": "hits.hits._source.ingested", "newer_than": "10m"}`, false},
+		{`{"field": "hits.hits._source.ingested", "after": "19/10/2023 15:00||-1h", "time_format": "02/01/2006 15:04"}`, true},
+		{`{"field": "hits.hits._source.ingested", "before": "19/10/2023 14:00", "time_format": "02/01/2006 15:04"}`, false},
+	}
+	for _, c := range cases {
+		var condition Condition
+		decodeJSON(t, c.condition, &condition)
+		if err := condition.validate(); err != nil {
+			t.Fatalf("condition %s should be valid: %v", c.condition, err)
+		}
+		if met := ConditionsMet(resp, []Condition{condition}); met != c.met {
+			t.Errorf("expected condition %s to evaluate to %t", c.condition, c.met)
+		}
+	}
+
+	invalid := []string{
+		`{"field": "ts", "older_than": "soon"}`,
+		`{"field": "ts", "older_than": 15}`,
+		`{"field": "ts", "before": "now-1q"}`,
+		`{"field": "ts", "after": "now", "time_format": "iso"}`,
+	}
+	for _, data := range invalid {
+		var condition Condition
+		decodeJSON(t, data, &condition)
+		if err := condition.validate(); err == nil {
+			t.Errorf("expected condition %s to be invalid", data)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"encoding/json"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/lbzss/elasticsearch-alert/utils"
+)
+
+// exprResponse is the search response as seen by expressions. Its fixed
+// fields are typed, so that expressions misspelling them or comparing
+// them to values of another type fail to compile. Aggregations and
+// suggestions depend on the query and are left untyped.
+type exprResponse struct {
+	Took         float64                `expr:"took"`
+	TimedOut     bool                   `expr:"timed_out"`
+	Shards       exprShards             `expr:"_shards"`
+	Hits         exprHits               `expr:"hits"`
+	Aggregations map[string]interface{} `expr:"aggregations"`
+	Suggest      map[string]interface{} `expr:"suggest"`
+
+	// Previous is the response of the rule's previous run, nil before
+	// the first run
+	Previous *exprResponse `expr:"previous"`
+}
+
+type exprShards struct {
+	Total      float64 `expr:"total"`
+	Successful float64 `expr:"successful"`
+	Skipped    float64 `expr:"skipped"`
+	Failed     float64 `expr:"failed"`
+}
+
+type exprHits struct {
+	Total    exprTotal                `expr:"total"`
+	MaxScore float64                  `expr:"max_score"`
+	Hits     []map[string]interface{} `expr:"hits"`
+}
+
+type exprTotal struct {
+	Value    float64 `expr:"value"`
+	Relation string  `expr:"relation"`
+}
+
+// newExprResponse converts a decoded search response. Missing and null
+// fields are zero values, and 'hits.total' may be a plain number as with
+// 'rest_total_hits_as_int'.
+func newExprResponse(resp map[string]interface{}) *exprResponse {
+	r := &exprResponse{
+		Took:         exprNumber(resp["took"]),
+		Aggregations: exprMap(resp["aggregations"]),
+		Suggest:      exprMap(resp["suggest"]),
+	}
+	r.TimedOut, _ = resp["timed_out"].(bool)
+
+	if shards, ok := resp["_shards"].(map[string]interface{}); ok {
+		r.Shards = exprShards{
+			Total:      exprNumber(shards["total"]),
+			Successful: exprNumber(shards["successful"]),
+			Skipped:    exprNumber(shards["skipped"]),
+			Failed:     exprNumber(shards["failed"]),
+		}
+	}
+
+	if hits, ok := resp["hits"].(map[string]interface{}); ok {
+		r.Hits.MaxScore = exprNumber(hits["max_score"])
+		switch total := hits["total"].(type) {
+		case map[string]interface{}:
+			r.Hits.Total.Value = exprNumber(total["value"])
+			r.Hits.Total.Relation, _ = total["relation"].(string)
+		default:
+			r.Hits.Total.Value = exprNumber(total)
+			r.Hits.Total.Relation = "eq"
+		}
+		list, _ := hits["hits"].([]interface{})
+		r.Hits.Hits = make([]map[string]interface{}, 0, len(list))
+		for _, hit := range list {
+			if m, ok := utils.FloatNumbers(hit).(map[string]interface{}); ok {
+				r.Hits.Hits = append(r.Hits.Hits, m)
+			}
+		}
+	}
+
+	if previous, ok := resp[PreviousKey].(map[string]interface{}); ok {
+		r.Previous = newExprResponse(previous)
+	}
+	return r
+}
+
+func exprNumber(v interface{}) float64 {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+func exprMap(v interface{}) map[string]interface{} {
+	m, _ := utils.FloatNumbers(v).(map[string]interface{})
+	if m == nil {
+		return map[string]interface{}{}
+	}
+	return m
+}
+
+// exprMet evaluates an expression condition. Numbers of the response are
+// float64s in expressions. Expressions that fail, e.g. because a field
+// is missing, are not met.
+func exprMet(resp map[string]interface{}, program *vm.Program) (bool, error) {
+	out, err := expr.Run(program, *newExprResponse(resp))
+	if err != nil {
+		return false, err
+	}
+	met, _ := out.(bool)
+	return met, nil
+}
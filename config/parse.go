@@ -170,6 +170,51 @@ func ParseConfig() (*Config, error) {
 	if len(rules) < 1 {
 		return nil, errors.New("at least one rule must be specified")
 	}
+
+	if err := cfg.validateRouting(); err != nil {
+		return nil, fmt.Errorf("error in main configuration file %s: %v", configFile, err)
+	}
+
+	for i, rule := range cfg.InhibitRules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("error in inhibit rule %d of main configuration file %s: %v", i+1, configFile, err)
+		}
+	}
+
+	if cfg.Kibana != nil {
+		if err := cfg.Kibana.validate(); err != nil {
+			return nil, fmt.Errorf("error in main configuration file %s: %v", configFile, err)
+		}
+	}
+
+	if cfg.HTTP != nil {
+		if err := cfg.HTTP.validate(); err != nil {
+			return nil, fmt.Errorf("error in main configuration file %s: %v", configFile, err)
+		}
+	}
+
+	// Rules are only allowed to leave out outputs if their alerts can be
+	// routed to receivers or escalated instead
+	if cfg.Route == nil {
+		for _, rule := range rules {
+			if len(rule.Outputs) < 1 && len(rule.Escalation) < 1 {
+				return nil, fmt.Errorf("at least one output must be specified ('outputs') for rule %s", rule.Name)
+			}
+		}
+	}
+	// Alerts of rules without outputs may be routed to any receiver
+	for _, rule := range rules {
+		if rule.Attachment == nil || len(rule.Outputs) > 0 || len(rule.Escalation) > 0 {
+			continue
+		}
+		for _, receiver := range cfg.Receivers {
+			for i, output := range receiver.Outputs {
+				if err := output.validateAttachments(); err != nil {
+					return nil, fmt.Errorf("error in output %d of receiver %s, which rule %s may be routed to: %v", i+1, receiver.Name, rule.Name, err)
+				}
+			}
+		}
+	}
 	cfg.Rules = rules
 	return cfg, nil
 }
@@ -203,7 +248,7 @@ func ParseRules() ([]RuleConfig, error) {
 		dec.UseNumber()
 
 		var rule RuleConfig
-		if err := dec.Decode(file); err != nil {
+		if err := dec.Decode(&rule); err != nil {
 			file.Close()
 			return nil, fmt.Errorf("error JSON-decoding rule file %s: %v", file.Name(), err)
 		}
@@ -224,6 +269,32 @@ func ParseRules() ([]RuleConfig, error) {
 	return rules, nil
 }
 
+// SilencesFile returns the path of the file silences are persisted in.
+func SilencesFile() (string, error) {
+	silencesFile := defaultSilences
+	if v := os.Getenv(envSilencesFile); v != "" {
+		f, err := homedir.Expand(v)
+		if err != nil {
+			return "", fmt.Errorf("error expanding silences file: %v", err)
+		}
+		silencesFile = f
+	}
+	return silencesFile, nil
+}
+
+// AcksFile returns the path of the file acknowledgements are persisted in.
+func AcksFile() (string, error) {
+	acksFile := defaultAcks
+	if v := os.Getenv(envAcksFile); v != "" {
+		f, err := homedir.Expand(v)
+		if err != nil {
+			return "", fmt.Errorf("error expanding acknowledgements file: %v", err)
+		}
+		acksFile = f
+	}
+	return acksFile, nil
+}
+
 func parseBody(v interface{}) (map[string]interface{}, error) {
 	switch b := v.(type) {
 	case map[string]interface{}:
@@ -0,0 +1,46 @@
+package config
+
+// Trace explains the evaluation of a condition, see ExplainConditions.
+type Trace struct {
+	// Condition is the condition as written in the rule
+	Condition map[string]interface{} `json:"condition"`
+	Met       bool                   `json:"met"`
+
+	// Error tells why the condition could not be evaluated or why its
+	// field was found wanting before its values were checked
+	Error string `json:"error,omitempty"`
+
+	// Group and Members are set for condition groups
+	Group   string   `json:"group,omitempty"`
+	Members []*Trace `json:"members,omitempty"`
+
+	// Field, Quantifier and Values are set for conditions on a field,
+	// Values holding one entry per value found at Field
+	Field      string        `json:"field,omitempty"`
+	Quantifier string        `json:"quantifier,omitempty"`
+	Values     []*ValueTrace `json:"values,omitempty"`
+}
+
+// ValueTrace explains the checks of a value found at the field of a
+// condition.
+type ValueTrace struct {
+	// Path is where the value was found in the response
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+
+	// Previous is the value found at the same place in the previous
+	// run's response, if the condition compares values to previous ones
+	Previous interface{} `json:"previous,omitempty"`
+
+	Operators []*OperatorTrace `json:"operators,omitempty"`
+	Satisfied bool             `json:"satisfied"`
+
+	// Ignored is true if the value is of a type no operator applies to
+	Ignored bool `json:"ignored,omitempty"`
+}
+
+// OperatorTrace is the result of one operator of a condition for a value.
+type OperatorTrace struct {
+	Operator string `json:"operator"`
+	Met      bool   `json:"met"`
+}
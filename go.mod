@@ -10,6 +10,8 @@ require (
 )
 
 require (
+	github.com/expr-lang/expr v1.17.8
+	github.com/jmespath/go-jmespath v0.4.0
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/mitchellh/mapstructure v1.5.0
 	github.com/robfig/cron v1.2.0
@@ -1 +1,11 @@
 package main
+
+import (
+	"os"
+
+	"github.com/lbzss/elasticsearch-alert/command"
+)
+
+func main() {
+	os.Exit(command.Run(os.Args[1:]))
+}
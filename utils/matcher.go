@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Matcher matches values by Value. If Regex is true Value is a regular
+// expression which must match the whole value, otherwise the value must
+// equal Value. Negate inverts the result. Name tells what the values are
+// of, e.g. the label of an alert.
+type Matcher struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Regex  bool   `json:"regex,omitempty"`
+	Negate bool   `json:"negate,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// ParseMatcher parses a matcher of the form 'name=value', 'name!=value',
+// 'name=~regex' or 'name!~regex'.
+func ParseMatcher(s string) (*Matcher, error) {
+	for _, op := range []string{"!=", "=~", "!~", "="} {
+		i := strings.Index(s, op)
+		if i < 0 {
+			continue
+		}
+		m := &Matcher{
+			Name:   strings.TrimSpace(s[:i]),
+			Value:  strings.TrimSpace(s[i+len(op):]),
+			Regex:  op == "=~" || op == "!~",
+			Negate: op == "!=" || op == "!~",
+		}
+		if err := m.Validate(); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("invalid matcher %q, expected one of name=value, name!=value, name=~regex or name!~regex", s)
+}
+
+// Validate checks the matcher and compiles its regular expression.
+func (m *Matcher) Validate() error {
+	if m.Name == "" {
+		return errors.New("matcher must have a name")
+	}
+
+	if m.Regex {
+		re, err := regexp.Compile("^(?:" + m.Value + ")$")
+		if err != nil {
+			return fmt.Errorf("error compiling regular expression of matcher %q: %v", m.Name, err)
+		}
+		m.re = re
+	}
+	return nil
+}
+
+func (m *Matcher) String() string {
+	op := "="
+	switch {
+	case m.Regex && m.Negate:
+		op = "!~"
+	case m.Regex:
+		op = "=~"
+	case m.Negate:
+		op = "!="
+	}
+	return m.Name + op + m.Value
+}
+
+// MatchesAny reports whether one of values matches, or whether none does
+// if the matcher is negated.
+func (m *Matcher) MatchesAny(values []string) bool {
+	matched := false
+	for _, v := range values {
+		if m.matches(v) {
+			matched = true
+			break
+		}
+	}
+	return matched != m.Negate
+}
+
+func (m *Matcher) matches(v string) bool {
+	if !m.Regex {
+		return v == m.Value
+	}
+	if m.re == nil {
+		if err := m.Validate(); err != nil {
+			return false
+		}
+	}
+	return m.re.MatchString(v)
+}
@@ -0,0 +1,33 @@
+package utils
+
+import "testing"
+
+func TestMatcher(t *testing.T) {
+	cases := []struct {
+		matcher string
+		values  []string
+		matches bool
+	}{
+		{"key=web-1", []string{"db-1", "web-1"}, true},
+		{"key!=web-1", []string{"db-1", "web-1"}, false},
+		{"key!=web-1", nil, true},
+		{"key=~web-.*", []string{"web-12"}, true},
+		{"key=~web", []string{"web-12"}, false},
+		{"key!~db-.*", []string{"web-1"}, true},
+	}
+	for _, c := range cases {
+		m, err := ParseMatcher(c.matcher)
+		if err != nil {
+			t.Fatalf("%s: %v", c.matcher, err)
+		}
+		if m.MatchesAny(c.values) != c.matches {
+			t.Errorf("%s: expected match of %v to be %v", c.matcher, c.values, c.matches)
+		}
+	}
+
+	for _, invalid := range []string{"key", "=web-1", "key=~("} {
+		if _, err := ParseMatcher(invalid); err == nil {
+			t.Errorf("%s: expected error", invalid)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jmespath/go-jmespath"
+)
+
+// JMESPathPrefix marks a path as a JMESPath expression rather than a list
+// of keys separated by dots, e.g.
+// 'jmespath:hits.hits[?_source."system.syslog.program" == `sshd`]'.
+const JMESPathPrefix = "jmespath:"
+
+var jmesPaths sync.Map
+
+// ValidatePath returns an error if path is not a valid path, i.e. if it
+// is a JMESPath expression that doesn't compile.
+func ValidatePath(path string) error {
+	if !strings.HasPrefix(path, JMESPathPrefix) {
+		return nil
+	}
+	_, err := compileJMESPath(path)
+	return err
+}
+
+func compileJMESPath(path string) (*jmespath.JMESPath, error) {
+	if v, ok := jmesPaths.Load(path); ok {
+		return v.(*jmespath.JMESPath), nil
+	}
+
+	expr := strings.TrimPrefix(path, JMESPathPrefix)
+	if strings.TrimSpace(expr) == "" {
+		return nil, fmt.Errorf("empty JMESPath expression in %q", path)
+	}
+	compiled, err := jmespath.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling JMESPath expression %q: %v", expr, err)
+	}
+	jmesPaths.Store(path, compiled)
+	return compiled, nil
+}
+
+// findJMESPath evaluates a JMESPath expression. A list result yields one
+// match per element. As JMESPath only compares float64 numbers, numbers
+// are converted for the evaluation and back to json.Number in the result,
+// so integers beyond 2^53 lose precision.
+func findJMESPath(doc map[string]interface{}, path string) []Match {
+	compiled, err := compileJMESPath(path)
+	if err != nil {
+		fmt.Println("invalid path", "path", path, "error", err)
+		return []Match{}
+	}
+
+	result, err := compiled.Search(FloatNumbers(doc))
+	if err != nil {
+		fmt.Println("error evaluating JMESPath expression", "path", path, "error", err)
+		return []Match{}
+	}
+
+	matches := make([]Match, 0)
+	if list, ok := result.([]interface{}); ok {
+		for i, e := range list {
+			matches = append(matches, Match{Value: JSONNumbers(e), Path: indexPath(path, i)})
+		}
+		return matches
+	}
+	if result != nil {
+		matches = append(matches, Match{Value: JSONNumbers(result), Path: path})
+	}
+	return matches
+}
+
+// FloatNumbers returns a copy of v, a decoded JSON value, with its
+// json.Numbers converted to float64s.
+func FloatNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if f, err := t.Float64(); err == nil {
+			return f
+		}
+		return t.String()
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[k] = FloatNumbers(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, e := range t {
+			l[i] = FloatNumbers(e)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// JSONNumbers returns a copy of v with its float64s converted to
+// json.Numbers, reversing FloatNumbers.
+func JSONNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case float64:
+		return json.Number(strconv.FormatFloat(t, 'f', -1, 64))
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[k] = JSONNumbers(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, e := range t {
+			l[i] = JSONNumbers(e)
+		}
+		return l
+	default:
+		return v
+	}
+}
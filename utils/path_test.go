@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFindJMESPath(t *testing.T) {
+	var resp map[string]interface{}
+	data := `{"hits": {"hits": [
+		{"_id": "1", "_source": {"system.syslog.program": "sshd", "bytes": 12}},
+		{"_id": "2", "_source": {"system.syslog.program": "cron", "bytes": 40}},
+		{"_id": "3", "_source": {"system.syslog.program": "sshd", "bytes": 90}}
+	]}}`
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	matches := Find(resp, "jmespath:hits.hits[?_source.\"system.syslog.program\" == 'sshd' && _source.bytes > `50`]._id")
+	if len(matches) != 1 || matches[0].Value != "3" {
+		t.Fatalf("expected the _id of the third hit, got %v", matches)
+	}
+
+	matches = Find(resp, "jmespath:hits.hits[0]._source.bytes")
+	if len(matches) != 1 || matches[0].Value != json.Number("12") {
+		t.Fatalf("expected numbers to be returned as json.Number, got %v", matches)
+	}
+
+	if matches := Find(resp, "jmespath:hits.hits[?_id == '9']"); len(matches) != 0 {
+		t.Fatalf("expected no matches, got %v", matches)
+	}
+
+	if err := ValidatePath("jmespath:hits.hits[?"); err == nil {
+		t.Fatal("expected invalid expressions to be rejected")
+	}
+	if err := ValidatePath("hits.hits"); err != nil {
+		t.Fatalf("expected dot paths to be valid, got %v", err)
+	}
+}
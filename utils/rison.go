@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const risonNotIDChars = " '!:(),*@$"
+
+// Rison encodes v in Rison, the URL-friendly JSON variant Kibana uses
+// for its application state. v may be made of maps, slices, strings,
+// numbers, booleans and nil, as produced by decoding JSON.
+func Rison(v interface{}) string {
+	var b strings.Builder
+	writeRison(&b, v)
+	return b.String()
+}
+
+func writeRison(b *strings.Builder, v interface{}) {
+	switch t := v.(type) {
+	case nil:
+		b.WriteString("!n")
+	case bool:
+		if t {
+			b.WriteString("!t")
+		} else {
+			b.WriteString("!f")
+		}
+	case string:
+		writeRisonString(b, t)
+	case json.Number:
+		b.WriteString(t.String())
+	case int:
+		b.WriteString(strconv.Itoa(t))
+	case int64:
+		b.WriteString(strconv.FormatInt(t, 10))
+	case float64:
+		b.WriteString(strconv.FormatFloat(t, 'f', -1, 64))
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b.WriteByte('(')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeRisonString(b, k)
+			b.WriteByte(':')
+			writeRison(b, t[k])
+		}
+		b.WriteByte(')')
+	case []interface{}:
+		b.WriteString("!(")
+		for i, e := range t {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeRison(b, e)
+		}
+		b.WriteByte(')')
+	default:
+		writeRisonString(b, fmt.Sprint(t))
+	}
+}
+
+// writeRisonString writes s bare if it is a valid Rison identifier and
+// quoted otherwise.
+func writeRisonString(b *strings.Builder, s string) {
+	if isRisonID(s) {
+		b.WriteString(s)
+		return
+	}
+
+	b.WriteByte('\'')
+	for _, r := range s {
+		if r == '\'' || r == '!' {
+			b.WriteByte('!')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('\'')
+}
+
+func isRisonID(s string) bool {
+	if s == "" || strings.ContainsAny(s, risonNotIDChars) {
+		return false
+	}
+	if c := s[0]; c == '-' || (c >= '0' && c <= '9') {
+		return false
+	}
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRison(t *testing.T) {
+	cases := []struct {
+		in       interface{}
+		expected string
+	}{
+		{nil, "!n"},
+		{true, "!t"},
+		{"kuery", "kuery"},
+		{"", "''"},
+		{"it's 10:00!", "'it!'s 10:00!!'"},
+		{"-1", "'-1'"},
+		{json.Number("1.5"), "1.5"},
+		{[]interface{}{}, "!()"},
+		{
+			map[string]interface{}{
+				"query":   map[string]interface{}{"term": map[string]interface{}{"host.name": "web 1"}},
+				"filters": []interface{}{false, 2},
+			},
+			"(filters:!(!f,2),query:(term:(host.name:'web 1')))",
+		},
+	}
+	for _, c := range cases {
+		if got := Rison(c.in); got != c.expected {
+			t.Errorf("Rison(%#v): expected %s, got %s", c.in, c.expected, got)
+		}
+	}
+}
@@ -1,89 +1,204 @@
 package utils
 
-import "strings"
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
 
-func GetAll(json map[string]interface{}, path string) []interface{} {
-	raw := getall(0, strings.Split(path, "."), json, "")
-	if v, ok := raw.([]interface{}); ok {
-		return v
+// Match is a value found by Find.
+type Match struct {
+	Value interface{}
+
+	// Path is the full JSON path of the value, e.g.
+	// 'aggregations.hosts.buckets[3]'
+	Path string
+
+	// Keys are the keys of the buckets traversed on the way to the value,
+	// outermost first
+	Keys []string
+}
+
+// Key returns the key of the bucket matched, prefixed with the keys of
+// its parent buckets: the parent keys are joined with ' - ' and the
+// bucket's own key is appended with '-', e.g. 'prod-web-1' for the 'hosts'
+// bucket 'web-1' nested in the 'clusters' bucket 'prod', or
+// 'eu - prod-web-1' one level deeper. It returns the empty string if the
+// value is not a bucket.
+func (m Match) Key() string {
+	bucket, ok := m.Value.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	key, ok := BucketKey(bucket)
+	if !ok || key == "" {
+		return ""
+	}
+	if len(m.Keys) > 0 {
+		return strings.Join(m.Keys, " - ") + "-" + key
+	}
+	return key
+}
+
+// Find returns the values at path in json without modifying it. The path
+// is a list of keys separated by dots. Lists on the way, e.g. the buckets
+// of an aggregation, are descended into element by element, and a list
+// at the end of the path yields one match per element. Keys containing
+// dots themselves, e.g. the percentiles of a 'percentiles' aggregation,
+// are matched as a whole, see eachKey.
+//
+// Paths starting with JMESPathPrefix are JMESPath expressions instead.
+// Their matches carry no parent bucket keys.
+func Find(json map[string]interface{}, path string) []Match {
+	if strings.HasPrefix(path, JMESPathPrefix) {
+		return findJMESPath(json, path)
 	}
-	return []interface{}{raw}
+
+	matches := make([]Match, 0)
+	find(strings.Split(path, "."), 0, json, "", nil, &matches)
+	return matches
 }
 
-func getall(i int, stack []string, elem interface{}, keychain string) interface{} {
+func find(stack []string, i int, elem interface{}, path string, keys []string, matches *[]Match) {
 	if i > len(stack)-1 {
 		if list, ok := elem.([]interface{}); ok {
-			var mod []interface{}
-			for _, e := range list {
-				mod = append(mod, addKey(e, keychain))
+			for j, e := range list {
+				*matches = append(*matches, Match{Value: e, Path: indexPath(path, j), Keys: keys})
 			}
-			return mod
+			return
 		}
-
-		if m, ok := elem.(map[string]interface{}); ok {
-			return addKey(m, keychain)
-		}
-		return elem
+		*matches = append(*matches, Match{Value: elem, Path: path, Keys: keys})
+		return
 	}
 
-	key := stack[i]
-	if m, ok := elem.(map[string]interface{}); ok {
-		v, ok := m[key]
-		if !ok {
-			return nil
+	switch t := elem.(type) {
+	case map[string]interface{}:
+		eachKey(t, stack, i, func(key string, v interface{}, next int) bool {
+			if path != "" {
+				key = path + "." + key
+			}
+			n := len(*matches)
+			find(stack, next, v, key, keys, matches)
+			return len(*matches) > n
+		})
+	case []interface{}:
+		for j, item := range t {
+			kc := keys
+			if bucket, ok := item.(map[string]interface{}); ok {
+				if k, ok := BucketKey(bucket); ok && k != "" {
+					kc = append(keys[:len(keys):len(keys)], k)
+				}
+			}
+			find(stack, i, item, indexPath(path, j), kc, matches)
 		}
-		i++
-		return getall(i, stack, v, keychain)
 	}
+}
 
-	buckets, ok := elem.([]interface{})
-	if !ok {
-		return nil
-	}
+// Lookup returns the value at path in m. Unlike Find it does not descend
+// into lists. Keys containing dots are matched like they are by Find.
+func Lookup(m map[string]interface{}, path string) (interface{}, bool) {
+	return lookup(m, strings.Split(path, "."), 0)
+}
 
-	var mod []interface{}
-	for _, item := range buckets {
-		kc := keychain
-		if e, ok := item.(map[string]interface{}); ok {
-			if k, ok := e["key"].(string); ok {
-				if kc == "" {
-					kc = k
-				} else {
-					kc = kc + " - " + k
-				}
-			}
+func lookup(m map[string]interface{}, stack []string, i int) (interface{}, bool) {
+	var value interface{}
+	found := false
+	eachKey(m, stack, i, func(_ string, v interface{}, next int) bool {
+		if next > len(stack)-1 {
+			value, found = v, true
+			return true
 		}
-
-		a := getall(i, stack, item, kc)
-		switch v := a.(type) {
-		case map[string]interface{}:
-			mod = append(mod, v)
-		case []interface{}:
-			mod = append(mod, v...)
-		case nil:
-		default:
-			mod = append(mod, a)
+		if sub, ok := v.(map[string]interface{}); ok {
+			value, found = lookup(sub, stack, next)
 		}
+		return found
+	})
+	return value, found
+}
 
+// eachKey calls f with the keys of m made of stack[i] and the parts of
+// the path following it, the longest first, until f returns true. f gets
+// the key, its value and the index of the part of the path after the
+// key. Keys containing dots, e.g. the percentiles of a 'percentiles'
+// aggregation, are thus matched as a whole before the path is followed
+// into shorter keys.
+func eachKey(m map[string]interface{}, stack []string, i int, f func(key string, v interface{}, next int) bool) {
+	for j := len(stack) - 1; j >= i; j-- {
+		key := strings.Join(stack[i:j+1], ".")
+		if v, ok := m[key]; ok && f(key, v, j+1) {
+			return
+		}
 	}
+}
 
-	return mod
+func indexPath(path string, i int) string {
+	return path + "[" + strconv.Itoa(i) + "]"
 }
 
-func addKey(i interface{}, keychain string) interface{} {
-	obj, ok := i.(map[string]interface{})
-	if !ok {
-		return i
+// GetAll returns the values at path in json, see Find. Buckets are
+// returned as copies whose 'key' is the one returned by Match.Key.
+//
+// Deprecated: use Find, which also reports where the values were found.
+func GetAll(json map[string]interface{}, path string) []interface{} {
+	matches := Find(json, path)
+	values := make([]interface{}, 0, len(matches))
+	for _, m := range matches {
+		values = append(values, m.Value)
+		if key := m.Key(); key != "" {
+			bucket := m.Value.(map[string]interface{})
+			cp := make(map[string]interface{}, len(bucket))
+			for k, v := range bucket {
+				cp[k] = v
+			}
+			cp["key"] = key
+			values[len(values)-1] = cp
+		}
 	}
-	key, ok := obj["key"].(string)
+	return values
+}
+
+// BucketKey returns the key of an aggregation bucket as a string. The
+// formatted 'key_as_string' of e.g. 'date_histogram' buckets is preferred,
+// numeric keys are formatted as they appear in the response and the keys
+// of 'composite' buckets are rendered as 'a=b, c=d', sorted by source.
+func BucketKey(bucket map[string]interface{}) (string, bool) {
+	if s, ok := bucket["key_as_string"].(string); ok && s != "" {
+		return s, true
+	}
+
+	raw, ok := bucket["key"]
 	if !ok {
-		return obj
+		return "", false
 	}
-	if key == "" {
-		return obj
+	if sources, ok := raw.(map[string]interface{}); ok {
+		names := make([]string, 0, len(sources))
+		for name := range sources {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		parts := make([]string, 0, len(names))
+		for _, name := range names {
+			parts = append(parts, name+"="+formatKey(sources[name]))
+		}
+		return strings.Join(parts, ", "), true
 	}
-	if keychain != "" {
-		obj["key"] = keychain + "-" + key
+	return formatKey(raw), true
+}
+
+func formatKey(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	default:
+		return fmt.Sprint(t)
 	}
-	return obj
 }
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestBucketKey(t *testing.T) {
+	var buckets []map[string]interface{}
+	data := `[
+		{"key": "web-1"},
+		{"key": 1697673600000, "key_as_string": "2023-10-19T00:00:00.000Z"},
+		{"key": 404},
+		{"key": 0.5},
+		{"key": {"service": "checkout", "status": 503}},
+		{"doc_count": 1}
+	]`
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&buckets); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"web-1", "2023-10-19T00:00:00.000Z", "404", "0.5", "service=checkout, status=503", ""}
+	for i, bucket := range buckets {
+		if key, _ := BucketKey(bucket); key != expected[i] {
+			t.Errorf("expected key %q, got %q", expected[i], key)
+		}
+	}
+	if _, ok := BucketKey(buckets[5]); ok {
+		t.Error("expected buckets without a key to have none")
+	}
+}
+
+func TestGetAllNumericKeys(t *testing.T) {
+	var resp map[string]interface{}
+	data := `{"aggregations": {"status": {"buckets": [
+		{"key": 500, "doc_count": 3, "hosts": {"buckets": [{"key": "web-1", "doc_count": 3}]}}
+	]}}}`
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	elems := GetAll(resp, "aggregations.status.buckets.hosts.buckets")
+	if len(elems) != 1 {
+		t.Fatalf("expected one bucket, got %v", elems)
+	}
+	if key := elems[0].(map[string]interface{})["key"]; key != "500-web-1" {
+		t.Fatalf("expected chained key '500-web-1', got %v", key)
+	}
+}
+
+func TestFind(t *testing.T) {
+	var resp map[string]interface{}
+	data := `{"aggregations": {"clusters": {"buckets": [
+		{"key": "prod", "hosts": {"buckets": [{"key": "web-1", "doc_count": 3}, {"key": "web-2", "doc_count": 1}]}},
+		{"key": "staging", "hosts": {"buckets": []}}
+	]}}}`
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	for run := 0; run < 2; run++ {
+		matches := Find(resp, "aggregations.clusters.buckets.hosts.buckets")
+		if len(matches) != 2 {
+			t.Fatalf("expected two matches, got %v", matches)
+		}
+
+		m := matches[1]
+		if m.Path != "aggregations.clusters.buckets[0].hosts.buckets[1]" {
+			t.Errorf("unexpected path %q", m.Path)
+		}
+		if len(m.Keys) != 1 || m.Keys[0] != "prod" {
+			t.Errorf("expected parent keys [prod], got %v", m.Keys)
+		}
+		if m.Key() != "prod-web-2" {
+			t.Errorf("expected key 'prod-web-2', got %q", m.Key())
+		}
+		if key := m.Value.(map[string]interface{})["key"]; key != "web-2" {
+			t.Fatalf("the response must not be modified, got key %v", key)
+		}
+	}
+
+	if matches := Find(resp, "aggregations.missing"); len(matches) != 0 {
+		t.Fatalf("expected no matches for a missing path, got %v", matches)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	doc := map[string]interface{}{
+		"latency": map[string]interface{}{"values": map[string]interface{}{"99.0": 812}},
+		"a.b":     1,
+		"a":       map[string]interface{}{"b": map[string]interface{}{"c": 2}},
+		"tags":    []interface{}{"x", "y"},
+	}
+
+	cases := map[string]interface{}{
+		"latency.values.99.0": 812,
+		"a.b":                 1,
+		"a.b.c":               2,
+	}
+	for path, expected := range cases {
+		v, ok := Lookup(doc, path)
+		if !ok || v != expected {
+			t.Errorf("%s: expected %v, got %v", path, expected, v)
+		}
+
+		// Find resolves keys containing dots the same way
+		if matches := Find(doc, path); len(matches) != 1 || matches[0].Value != expected {
+			t.Errorf("%s: expected Find to match %v, got %v", path, expected, matches)
+		}
+	}
+
+	if v, ok := Lookup(doc, "tags"); !ok || len(v.([]interface{})) != 2 {
+		t.Errorf("expected lists to be returned as a whole, got %v", v)
+	}
+	if _, ok := Lookup(doc, "latency.values.50.0"); ok {
+		t.Error("expected missing paths not to be found")
+	}
+}